@@ -1,168 +1,457 @@
 package apply
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"time"
+	"unicode"
 
+	"goctx/internal/baseline"
 	"goctx/internal/config"
+	"goctx/internal/git"
 	"goctx/internal/model"
 	"goctx/internal/patch"
-	"goctx/internal/runner"
+	"goctx/internal/pipeline"
 	"goctx/internal/stash"
+	"goctx/internal/trash"
 )
 
 type ProgressFunc func(phase, desc string, logLine string)
 
-const trashDir = ".trash"
+// Options tune a single ApplyPatchWithOptions call.
+type Options struct {
+	// AllowProtected lists protected paths the user confirmed may be changed.
+	AllowProtected []string
+	// Isolated verifies the patch in a private copy of the workspace before
+	// writing it, like the isolated setting in goctx.json.
+	Isolated bool
+	// OnStep, if set, receives the result of every verification step run on
+	// the patched files, from the goroutine applying the patch.
+	OnStep func(res pipeline.Result)
+}
+
+// DefaultConfirmScore is the similarity below which a scored match needs
+// explicit confirmation when goctx.json does not set matching.confirm_score.
+const DefaultConfirmScore = 0.95
+
+// FuzzyMatch records a hunk that only landed through similarity scoring.
+type FuzzyMatch struct {
+	Path  string
+	Hunk  int
+	Match patch.Match
+}
 
 func ApplyHunksToString(original string, hunks []patch.Hunk) (string, error) {
+	result, _, err := ApplyHunksWithOptions(original, hunks, patch.DefaultMatchOptions)
+	return result, err
+}
+
+// ApplyHunksWithOptions applies hunks in order and reports how each matched.
+// A failed hunk is skipped so the ones after it are still checked; the error
+// describes the first failure and the original string is returned.
+func ApplyHunksWithOptions(original string, hunks []patch.Hunk, opts patch.MatchOptions) (string, []HunkReport, error) {
 	result := original
-	for _, h := range hunks {
-		newStr, ok := patch.ApplyHunk(result, h)
-		if !ok {
-			return original, fmt.Errorf("hunk match failed")
+	reports := make([]HunkReport, 0, len(hunks))
+	var firstErr error
+	for i, h := range hunks {
+		hr := HunkReport{Index: i + 1}
+		newStr, m := patch.ApplyHunkWithOptions(result, h, opts)
+		if m.Kind != patch.MatchNone {
+			switch m.Kind {
+			case patch.MatchExact:
+				hr.Status = HunkExact
+			case patch.MatchAlreadyApplied:
+				hr.Status = HunkAlreadyApplied
+			default:
+				hr.Status = HunkFuzzy
+			}
+			hr.Match = &m
+			result = newStr
+			reports = append(reports, hr)
+			continue
+		}
+
+		near, ambiguous := patch.NearestRegion(result, h)
+		hr.Status = HunkNotFound
+		if ambiguous && !opts.Strict && opts.MinScore > 0 && near.Score >= opts.MinScore {
+			hr.Status = HunkAmbiguous
+		}
+		if near.Score > 0 {
+			hr.NearMiss = &near
+			lines := strings.Split(result, "\n")
+			hr.NearMissText = strings.Join(lines[near.Start:min(near.End, len(lines))], "\n")
+		}
+		reports = append(reports, hr)
+
+		if firstErr == nil {
+			reason := "SEARCH block not found"
+			if hr.Status == HunkAmbiguous {
+				reason = "SEARCH block matches several regions equally well"
+			}
+			firstErr = fmt.Errorf("hunk %d of %d: %s", i+1, len(hunks), reason)
 		}
-		result = newStr
 	}
-	return result, nil
+	if firstErr != nil {
+		return original, reports, firstErr
+	}
+	return result, reports, nil
+}
+
+// MatchOptions translates the matching section of goctx.json into patch options.
+func MatchOptions(cfg model.Config) patch.MatchOptions {
+	opts := patch.DefaultMatchOptions
+	opts.Strict = cfg.Matching.Strict
+	if cfg.Matching.MinScore > 0 {
+		opts.MinScore = cfg.Matching.MinScore
+	}
+	return opts
+}
+
+// LowConfidenceMatches returns the hunks of the report that matched by
+// similarity below the confirmation score configured when it was planned.
+func (r *ApplyReport) LowConfidenceMatches() []FuzzyMatch {
+	threshold := r.confirmScore
+	if threshold <= 0 {
+		threshold = DefaultConfirmScore
+	}
+
+	var low []FuzzyMatch
+	for _, f := range r.Files {
+		for _, h := range f.Hunks {
+			if h.Status == HunkFuzzy && h.Match.Kind == patch.MatchSimilar && h.Match.Score < threshold {
+				low = append(low, FuzzyMatch{Path: f.Path, Hunk: h.Index, Match: *h.Match})
+			}
+		}
+	}
+	sort.Slice(low, func(i, j int) bool {
+		if low[i].Path != low[j].Path {
+			return low[i].Path < low[j].Path
+		}
+		return low[i].Hunk < low[j].Hunk
+	})
+	return low
 }
 
-// isFileDeletion detects if content represents a file deletion (empty or whitespace only)
-func isFileDeletion(content string) bool {
-	return strings.TrimSpace(content) == ""
+// isFileDeletion detects if content represents a file deletion: the explicit
+// DELETE marker or, unless requireMarker is set, empty/whitespace-only content.
+func isFileDeletion(content string, requireMarker bool) bool {
+	if patch.IsDeleteMarker(content) {
+		return true
+	}
+	return !requireMarker && strings.TrimSpace(content) == ""
 }
 
-// moveToTrash moves a file to the .trash directory instead of permanently deleting it
-func moveToTrash(root, filePath string) error {
-	targetPath := filepath.Join(root, filePath)
+// Deletions returns the files applying the patch would move to the trash.
+func (r *ApplyReport) Deletions() []string {
+	var paths []string
+	for _, f := range r.Files {
+		if f.Action == "delete" && !f.Blocked() {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths
+}
 
+// moveToTrash moves a file to the .trash directory instead of permanently deleting it.
+// It returns the trash entry ID, or "" if there was nothing to trash.
+func moveToTrash(root, filePath, description string) (string, error) {
 	// Check if file exists before attempting to move
-	_, err := os.Stat(targetPath)
-	if err != nil {
+	if _, err := os.Stat(filepath.Join(root, filePath)); err != nil {
 		if os.IsNotExist(err) {
 			// File doesn't exist, nothing to trash
-			return nil
+			return "", nil
 		}
-		return fmt.Errorf("could not stat file: %w", err)
+		return "", fmt.Errorf("could not stat file: %w", err)
 	}
 
-	// Create trash directory if it doesn't exist
-	trashPath := filepath.Join(root, trashDir)
-	if err := os.MkdirAll(trashPath, 0755); err != nil {
-		return fmt.Errorf("could not create trash directory: %w", err)
+	entry, err := trash.Move(root, filePath, description)
+	if err != nil {
+		return "", err
 	}
+	return entry.ID, nil
+}
 
-	// Generate unique trash filename to avoid collisions
-	baseFileName := filepath.Base(filePath)
-	timestamp := time.Now().Format("20060102-150405")
-	trashFileName := fmt.Sprintf("%s_%s", timestamp, baseFileName)
-	trashedPath := filepath.Join(trashPath, trashFileName)
-
-	// Move file to trash
-	if err := os.Rename(targetPath, trashedPath); err != nil {
-		return fmt.Errorf("could not move file to trash: %w", err)
-	}
+// ApplyPatch applies input as a single transaction: every file is computed and
+// validated in memory first, then written via temp files and renames. If any
+// write fails, every touched path is restored to its exact previous bytes.
+// The returned report describes every file and hunk, even on failure.
+// Protected files are rejected; see ApplyPatchWithOptions.
+func ApplyPatch(root string, input model.ProjectOutput, onProgress ProgressFunc) (*ApplyReport, error) {
+	return ApplyPatchWithOptions(root, input, onProgress, Options{})
+}
 
-	return nil
+// ApplyPatchWithOptions is ApplyPatch with per-call options, such as the
+// protected files the user confirmed.
+func ApplyPatchWithOptions(root string, input model.ProjectOutput, onProgress ProgressFunc, opts Options) (*ApplyReport, error) {
+	return ApplyPatchContext(context.Background(), root, input, onProgress, opts)
 }
 
-func ApplyPatch(root string, input model.ProjectOutput, onProgress ProgressFunc) error {
+// ApplyPatchContext is ApplyPatchWithOptions with a context that cancels
+// the verification steps. A cancelled or timed-out step is handled like a
+// failed one: the touched files are stashed and rolled back.
+func ApplyPatchContext(ctx context.Context, root string, input model.ProjectOutput, onProgress ProgressFunc, opts Options) (*ApplyReport, error) {
 	if len(input.Files) == 0 {
-		return fmt.Errorf("no files to apply")
+		return nil, fmt.Errorf("no files to apply")
+	}
+
+	cfg, err := config.Load(root)
+	if err != nil {
+		return nil, fmt.Errorf("PATCH_ERROR: invalid configuration: %w", err)
 	}
 
 	if onProgress != nil {
-		onProgress("Applying", "Modifying workspace files...", "")
+		onProgress("Validating", "Matching hunks against workspace...", "")
 	}
 
-	for path, content := range input.Files {
-		if !safePath(root, path) {
-			continue
-		}
+	changes, report, err := planPatch(root, input, newPlanOptions(cfg, opts))
+	if err != nil {
+		return report, fmt.Errorf("PATCH_ERROR: %w", err)
+	}
 
-		// Check if this is a deletion (empty/whitespace-only content)
-		if isFileDeletion(content) {
-			if onProgress != nil {
-				onProgress("", fmt.Sprintf("Trashing: %s", path), "")
-			}
-			if err := moveToTrash(root, path); err != nil {
-				return fmt.Errorf("TRASH_ERROR: Could not trash file %s: %w", path, err)
+	for _, c := range changes {
+		for _, h := range c.hunks {
+			if h.Status == HunkFuzzy && onProgress != nil {
+				onProgress("", "", fmt.Sprintf("Fuzzy match: %s hunk %d at lines %d-%d (%.0f%% confidence)", c.path, h.Index, h.Match.Start+1, h.Match.End, h.Match.Score*100))
 			}
-			continue
 		}
+	}
+
+	touched := touchedPaths(changes)
+	var base *baseline.Baseline
+	if cfg.Baseline && len(cfg.Scripts) > 0 {
+		// Failing to capture is logged; the patch is then judged alone
+		base, _ = captureBaseline(ctx, root, cfg.Scripts, touched, onProgress)
+		if ctx.Err() != nil {
+			return report, fmt.Errorf("PATCH_ERROR: cancelled while verifying the unpatched workspace; nothing was changed")
+		}
+	}
 
-		targetPath := filepath.Join(root, path)
-		os.MkdirAll(filepath.Dir(targetPath), 0755)
+	if cfg.Isolated || opts.Isolated {
+		return applyIsolated(ctx, root, input, cfg, opts, report, touched, base, onProgress)
+	}
 
-		var applyErr error
-		if strings.Contains(content, "<<<<<< SEARCH") && strings.Contains(content, ">>>>>> REPLACE") {
-			hunks := patch.ParseHunks(content)
-			if len(hunks) > 0 {
-				applyErr = applySurgicalEdit(targetPath, hunks)
+	if onProgress != nil {
+		onProgress("Applying", "Modifying workspace files...", "")
+	}
+
+	tx := &transaction{root: root, description: input.ShortDescription}
+	if err := tx.commit(changes, onProgress); err != nil {
+		if rbErr := tx.rollback(); rbErr != nil {
+			return report, fmt.Errorf("PATCH_ERROR: %w (rollback failed: %v)", err, rbErr)
+		}
+		return report, fmt.Errorf("PATCH_ERROR: %w (workspace restored)", err)
+	}
+
+	if len(cfg.Formatters) > 0 {
+		if onProgress != nil {
+			onProgress("Formatting", "Running formatters on touched files...", "")
+		}
+		formatChanges(ctx, root, changes, cfg.Formatters, report, onProgress)
+		if ctx.Err() != nil {
+			return report, cancelledFormatting(tx)
+		}
+	}
+
+	// The touched files are saved as they failed, in git, and then always
+	// rolled back from the transaction's own copies, so the exact pre-patch
+	// bytes and modes return whether or not stashing worked. Nothing else in
+	// the workspace is touched.
+	restore := func(message string) string {
+		var note string
+		if git.IsRepo(root) {
+			if entry, err := stash.Save(root, message, touched); err != nil {
+				note = fmt.Sprintf("\n\nThe patched files could not be stashed: %v", err)
 			} else {
-				applyErr = fmt.Errorf("surgical markers found but failed to parse hunks in %s", path)
+				report.Recovery = entry
+				note = fmt.Sprintf("\n\nThe patched files were stashed as %s.", entry.Short())
 			}
-		} else {
-			applyErr = os.WriteFile(targetPath, []byte(content), 0644)
 		}
-
-		if applyErr != nil {
-			return fmt.Errorf("PATCH_ERROR: Critical failure at %s: %w", path, applyErr)
+		if err := tx.rollback(); err != nil {
+			return note + fmt.Sprintf("\n\nRollback failed: %v", err)
 		}
+		return note + "\n\nWorkspace restored to its pre-patch state."
 	}
 
-	cfg, _ := config.Load(root)
+	if failed := verify(ctx, root, cfg, touched, base, report, onProgress, opts.OnStep); failed != nil {
+		return report, verificationError(*failed, input.ShortDescription, restore)
+	}
 
-	if cfg.Scripts.Build != "" {
-		if onProgress != nil {
-			onProgress("Building", fmt.Sprintf("Running: %s", cfg.Scripts.Build), "")
-		}
-		if out, err := runner.Run(root, cfg.Scripts.Build, func(line string) {
+	return report, nil
+}
+
+// verify runs the pipeline in dir, judging failures against base when a
+// baseline was captured, and records test results and comparisons in
+// report. It returns the step that rejects the patch, or nil.
+func verify(ctx context.Context, dir string, cfg model.Config, touched []string, base *baseline.Baseline, report *ApplyReport, onProgress ProgressFunc, onStep func(pipeline.Result)) *pipeline.Result {
+	results := pipeline.Run(ctx, dir, cfg.Scripts, touched, pipeline.Hooks{
+		OnStart: func(step model.Step) {
+			if onProgress != nil {
+				onProgress(stepPhase(step), fmt.Sprintf("Running: %s", step.Run), "")
+			}
+		},
+		OnLog: func(step model.Step, line string) {
 			if onProgress != nil {
-				onProgress("Building", "", line)
+				onProgress(stepPhase(step), "", line)
 			}
-		}); err != nil {
-			stash.Push(root, fmt.Sprintf("Auto-stash: Build Failed - %s", input.ShortDescription))
-			return fmt.Errorf("BUILD_FAILURE: Verification failed for '%s'\n\nOutput:\n%s", cfg.Scripts.Build, string(out))
+		},
+		Accept: func(res pipeline.Result) bool {
+			c, ok := base.Compare(res)
+			return ok && !c.Regressed()
+		},
+		OnDone: func(res pipeline.Result) {
+			if c, ok := base.Compare(res); ok {
+				report.Baseline = append(report.Baseline, c)
+			}
+			if onStep != nil {
+				onStep(res)
+			}
+			if onProgress == nil {
+				return
+			}
+			switch {
+			case res.Accepted:
+				onProgress("", "", fmt.Sprintf("%s failed before the patch too; no new failures", pipeline.Label(res.Step)))
+			case res.Skipped:
+				onProgress("", "", fmt.Sprintf("Skipped %s: no changed file matches %s", pipeline.Label(res.Step), strings.Join(res.Step.Paths, ", ")))
+			case res.TimedOut() && res.Step.Advisory:
+				onProgress("", "", fmt.Sprintf("Advisory step %s %v", pipeline.Label(res.Step), res.Err))
+			case res.Failed() && res.Step.Advisory && !res.Canceled():
+				onProgress("", "", fmt.Sprintf("Advisory step %s failed: %v", pipeline.Label(res.Step), res.Err))
+			}
+		},
+	})
+	for _, res := range results {
+		if res.Tests != nil {
+			report.Tests = res.Tests
 		}
 	}
+	return pipeline.Failure(results)
+}
 
-	if cfg.Scripts.Test != "" {
-		if onProgress != nil {
-			onProgress("Testing", fmt.Sprintf("Running: %s", cfg.Scripts.Test), "")
-		}
-		if out, err := runner.Run(root, cfg.Scripts.Test, func(line string) {
+// verificationError describes the step that rejected a patch. restore
+// undoes the patch, given a message for the stash it is saved in, and
+// returns a note for the user. The note comes before the step's output, so
+// a long output neither buries it nor makes it hard to cut off.
+func verificationError(failed pipeline.Result, description string, restore func(message string) string) error {
+	name := pipeline.Label(failed.Step)
+	switch {
+	case failed.Canceled():
+		note := restore(fmt.Sprintf("Auto-stash: %s Cancelled - %s", stepPhase(failed.Step), description))
+		return fmt.Errorf("VERIFICATION_CANCELLED: Stopped during '%s'%s\n\nOutput:\n%s", failed.Step.Run, note, stepOutput(failed))
+	case failed.TimedOut():
+		note := restore(fmt.Sprintf("Auto-stash: %s Timed Out - %s", stepPhase(failed.Step), description))
+		return fmt.Errorf("%s_TIMEOUT: '%s' %v%s\n\nOutput:\n%s", failureKind(name), failed.Step.Run, failed.Err, note, stepOutput(failed))
+	}
+	note := restore(fmt.Sprintf("Auto-stash: %s Failed - %s", stepPhase(failed.Step), description))
+	return fmt.Errorf("%s_FAILURE: Verification failed for '%s'%s\n\nOutput:\n%s", failureKind(name), failed.Step.Run, note, stepOutput(failed))
+}
+
+// captureBaseline verifies the workspace before the patch is written. A
+// baseline that cannot be captured is logged and skipped, so every failure
+// then counts against the patch.
+func captureBaseline(ctx context.Context, root string, steps model.Scripts, touched []string, onProgress ProgressFunc) (*baseline.Baseline, error) {
+	if onProgress != nil {
+		onProgress("Baseline", "Verifying the unpatched workspace...", "")
+	}
+	base, err := baseline.Capture(ctx, root, steps, touched, pipeline.Hooks{
+		OnStart: func(step model.Step) {
 			if onProgress != nil {
-				onProgress("Testing", "", line)
+				onProgress("Baseline", fmt.Sprintf("Running: %s", step.Run), "")
 			}
-		}); err != nil {
-			stash.Push(root, fmt.Sprintf("Auto-stash: Tests Failed - %s", input.ShortDescription))
-			return fmt.Errorf("TEST_FAILURE: Verification failed for '%s'\n\nOutput:\n%s", cfg.Scripts.Test, string(out))
+		},
+		OnLog: func(step model.Step, line string) {
+			if onProgress != nil {
+				onProgress("Baseline", "", line)
+			}
+		},
+	})
+	if onProgress != nil {
+		switch {
+		case base == nil:
+			onProgress("", "", fmt.Sprintf("Baseline unavailable: %v", err))
+		case base.Cached:
+			onProgress("", "", fmt.Sprintf("Using cached baseline for tree %.12s", base.Tree))
+		case err != nil:
+			onProgress("", "", fmt.Sprintf("Baseline not cached: %v", err))
 		}
 	}
+	return base, err
+}
 
-	return nil
+// touchedPaths lists every path a patch writes, moves or deletes, for
+// matching pipeline steps against.
+func touchedPaths(changes []fileChange) []string {
+	touched := []string{}
+	for _, c := range changes {
+		if c.unchanged() && c.from == "" {
+			continue
+		}
+		touched = append(touched, c.path)
+		if c.from != "" {
+			touched = append(touched, c.from)
+		}
+	}
+	return touched
 }
 
-func applySurgicalEdit(path string, hunks []patch.Hunk) error {
-	originalData, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("could not read file: %w", err)
+// stepOutput is what a failure message shows of a step: its failing tests
+// when it ran `go test -json`, its combined output otherwise.
+func stepOutput(res pipeline.Result) string {
+	failures := res.Tests.Failures()
+	if len(failures) == 0 {
+		return string(res.Output)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Tests: %s\n", res.Tests.Summary())
+	for _, t := range failures {
+		fmt.Fprintf(&sb, "\n--- FAIL: %s (%.2fs)\n%s", t.Name(), t.Elapsed.Seconds(), t.Output)
+	}
+	return sb.String()
+}
+
+// stepPhase is the progress phase shown while a step runs, e.g. "Build".
+func stepPhase(step model.Step) string {
+	label := pipeline.Label(step)
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// failureKind turns a step name into the error prefix, e.g. BUILD for
+// BUILD_FAILURE.
+func failureKind(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// surgicalContent applies hunks to existing file data in memory.
+func surgicalContent(originalData []byte, hunks []patch.Hunk, opts patch.MatchOptions) ([]byte, []HunkReport, error) {
+	// Patch normalized LF text, then write back with the file's own newline
+	// style, BOM and trailing newline so the diff only shows real changes.
+	format := detectTextFormat(originalData)
+	normalized := make([]patch.Hunk, len(hunks))
+	for i, h := range hunks {
+		normalized[i] = patch.Hunk{Search: format.normalize(h.Search), Replace: format.normalize(h.Replace)}
 	}
 
-	newStr, err := ApplyHunksToString(string(originalData), hunks)
+	newStr, reports, err := ApplyHunksWithOptions(format.normalize(string(originalData)), normalized, opts)
 	if err != nil {
-		return err
+		return nil, reports, err
 	}
 
-	return os.WriteFile(path, []byte(newStr), 0644)
+	return []byte(format.restore(newStr)), reports, nil
 }
 
-func safePath(root, path string) bool {
-	abs, _ := filepath.Abs(path)
-	rootAbs, _ := filepath.Abs(root)
-	return strings.HasPrefix(abs, rootAbs)
+// fullFileContent prepares a full-file replacement. Existing files keep their
+// newline style, BOM and trailing newline; new files are written as given.
+func fullFileContent(existing []byte, exists bool, content string) []byte {
+	if exists {
+		content = detectTextFormat(existing).restore(content)
+	}
+	return []byte(content)
 }
@@ -1,9 +1,12 @@
 package apply
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"goctx/internal/model"
 	"goctx/internal/patch"
 )
 
@@ -147,3 +150,27 @@ func TestApplyHunksToString_Overlapping(t *testing.T) {
 		t.Errorf("Expected %q, got %q", expected, result)
 	}
 }
+
+func TestLowConfidenceMatches(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	original := "func a() {\n\tx := 1\n\ty := 2\n\tz := 3\n\treturn x + y + z\n}\n"
+	if err := os.WriteFile(filepath.Join(root, "a.go"), []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.go": "<<<<<< SEARCH\nfunc a() {\n\tx := 1\n\t// sum them\n\ty := 2\n\tz := 3\n\treturn x + y + z\n======\nfunc a() {\n\tx := 10\n\ty := 2\n\tz := 3\n\treturn x + y + z\n>>>>>> REPLACE",
+	}}
+
+	low := mustCheck(t, ".", input).LowConfidenceMatches()
+	if len(low) != 1 || low[0].Path != "a.go" || low[0].Hunk != 1 {
+		t.Fatalf("expected one low confidence hunk, got %+v", low)
+	}
+
+	strict := MatchOptions(model.Config{Matching: model.Matching{Strict: true}})
+	hunks := patch.ParseHunks(input.Files["a.go"])
+	if _, _, err := ApplyHunksWithOptions(original, hunks, strict); err == nil {
+		t.Fatal("strict mode should refuse the similarity match")
+	}
+}
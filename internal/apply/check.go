@@ -0,0 +1,41 @@
+package apply
+
+import (
+	"fmt"
+
+	"goctx/internal/config"
+	"goctx/internal/model"
+)
+
+// Check validates every file and hunk of input against the workspace at root
+// without writing anything. Every problem in the patch is reported at once.
+// The error is only set when the patch cannot be checked at all, such as
+// for an invalid goctx.json.
+func Check(root string, input model.ProjectOutput) (*ApplyReport, error) {
+	return CheckWithOptions(root, input, Options{})
+}
+
+// CheckWithOptions is Check with the same options ApplyPatchWithOptions takes.
+func CheckWithOptions(root string, input model.ProjectOutput, opts Options) (*ApplyReport, error) {
+	cfg, err := config.Load(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	_, report, _ := planPatch(root, input, newPlanOptions(cfg, opts))
+	return report, nil
+}
+
+// ProtectedFiles returns the files of the patch that need confirmation
+// because they match a protected pattern. A nil report has none.
+func (r *ApplyReport) ProtectedFiles() []string {
+	if r == nil {
+		return nil
+	}
+	var paths []string
+	for _, f := range r.Files {
+		if f.Protected {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths
+}
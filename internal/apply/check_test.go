@@ -0,0 +1,120 @@
+package apply
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goctx/internal/model"
+)
+
+// mustCheck is Check for a workspace whose configuration is known to load.
+func mustCheck(t *testing.T, root string, input model.ProjectOutput) *ApplyReport {
+	t.Helper()
+	report, err := Check(root, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	new := "a\nb\nc\nd\nE\nf\ng\nh\ni\nj\nk\n"
+
+	want := "--- a/x.txt\n+++ b/x.txt\n" +
+		"@@ -2,9 +2,10 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n i\n j\n+k\n"
+	if got := UnifiedDiff("x.txt", []byte(old), []byte(new)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := UnifiedDiff("x.txt", []byte(old), []byte(old)); got != "" {
+		t.Errorf("identical inputs should produce no diff, got %q", got)
+	}
+
+	created := UnifiedDiff("n.txt", nil, []byte("one\ntwo"))
+	if !strings.HasPrefix(created, "--- /dev/null\n+++ b/n.txt\n@@ -0,0 +1,2 @@\n+one\n+two\n\\ No newline at end of file\n") {
+		t.Errorf("unexpected new file diff:\n%s", created)
+	}
+}
+
+func TestCheckReportsEveryFile(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"a.go": "alpha\n", "b.go": "beta\n"})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.go": "<<<<<< SEARCH\nmissing\n======\nx\n>>>>>> REPLACE",
+		"b.go": "<<<<<< SEARCH\nbeta\n======\nBETA\n>>>>>> REPLACE",
+		"c.go": "package c",
+	}}
+
+	report := mustCheck(t, ".", input)
+	if report.OK() {
+		t.Fatal("expected check to fail")
+	}
+	if len(report.Files) != 3 {
+		t.Fatalf("expected 3 file reports, got %d", len(report.Files))
+	}
+	a, b, c := report.Files[0], report.Files[1], report.Files[2]
+	if a.OK() || !strings.Contains(a.Error, "hunk 1 of 1") {
+		t.Errorf("a.go should fail on hunk 1, got %q", a.Error)
+	}
+	if len(a.Hunks) != 1 || a.Hunks[0].Status != HunkNotFound {
+		t.Errorf("a.go hunk should be reported not found, got %+v", a.Hunks)
+	}
+	if !b.OK() || b.Action != "modify" || !strings.Contains(b.Diff, "+BETA") {
+		t.Errorf("unexpected b.go report: %+v", b)
+	}
+	if len(b.Hunks) != 1 || b.Hunks[0].Status != HunkExact || b.Hunks[0].Match.Start != 0 {
+		t.Errorf("b.go hunk should be an exact match on line 1, got %+v", b.Hunks)
+	}
+	if !c.OK() || c.Action != "create" {
+		t.Errorf("unexpected c.go report: %+v", c)
+	}
+
+	// Nothing may be written by a check
+	assertFile(t, ".", "b.go", "beta\n")
+}
+
+func TestCheckReportsPathsOutsideRoot(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.go": "alpha\n"})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"../outside.go": "package x",
+		"a.go":          "<<<<<< SEARCH\nalpha\n======\nALPHA\n>>>>>> REPLACE",
+	}}
+	report := mustCheck(t, root, input)
+	if len(report.Files) != 2 {
+		t.Fatalf("expected 2 file reports, got %d", len(report.Files))
+	}
+	if f := report.Files[0]; f.OK() || f.Action != "reject" || !strings.Contains(f.Error, "path escapes the project root") {
+		t.Errorf("../outside.go should be rejected as escaping the root, got %+v", f)
+	}
+	if !report.Files[1].OK() {
+		t.Errorf("a.go should still be checked: %+v", report.Files[1])
+	}
+	if _, err := os.Stat(filepath.Join(root, "..", "outside.go")); !os.IsNotExist(err) {
+		t.Errorf("a check must not write outside the root, stat: %v", err)
+	}
+}
+
+func TestCheckRejectsInvalidConfig(t *testing.T) {
+	root := t.TempDir()
+	// A number is not a duration; the steps must not silently disappear
+	writeFiles(t, root, map[string]string{
+		"goctx.json": `{"scripts": [{"name": "test", "run": "exit 1", "timeout": 600}], "protected": ["a.go"]}`,
+		"a.go":       "alpha\n",
+	})
+	input := model.ProjectOutput{Files: map[string]string{"a.go": "ALPHA\n"}}
+
+	if _, err := Check(root, input); err == nil || !strings.Contains(err.Error(), "goctx.json") {
+		t.Errorf("check should refuse an invalid goctx.json, got %v", err)
+	}
+	if _, err := ApplyPatch(root, input, nil); err == nil || !strings.Contains(err.Error(), "invalid configuration") {
+		t.Errorf("apply should refuse an invalid goctx.json, got %v", err)
+	}
+	assertFile(t, root, "a.go", "alpha\n")
+}
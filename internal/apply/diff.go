@@ -0,0 +1,118 @@
+package apply
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// UnifiedDiff renders the change from old to new as a unified diff. A nil old
+// marks a new file and a nil new marks a deletion. Identical inputs produce "".
+func UnifiedDiff(path string, old, new []byte) string {
+	return unifiedDiff(path, path, old, new)
+}
+
+// unifiedDiff is UnifiedDiff for a file that may have moved from fromPath.
+func unifiedDiff(fromPath, toPath string, old, new []byte) string {
+	if old != nil && new != nil && string(old) == string(new) {
+		return ""
+	}
+
+	dmp := diffmatchpatch.New()
+	a, b, lines := dmp.DiffLinesToChars(string(old), string(new))
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lines)
+
+	var ops []diffLine
+	for _, d := range diffs {
+		op := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		}
+		for _, l := range strings.SplitAfter(d.Text, "\n") {
+			if l != "" {
+				ops = append(ops, diffLine{op, l})
+			}
+		}
+	}
+
+	var sb strings.Builder
+	from, to := "a/"+fromPath, "b/"+toPath
+	if old == nil {
+		from = "/dev/null"
+	}
+	if new == nil {
+		to = "/dev/null"
+	}
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", from, to)
+
+	for start := 0; start < len(ops); {
+		// Find the next change and extend the hunk while changes are close
+		first := start
+		for first < len(ops) && ops[first].op == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first + 1; i < len(ops); i++ {
+			if ops[i].op != ' ' {
+				if i-last > 2*diffContext {
+					break
+				}
+				last = i
+			}
+		}
+
+		lo := max(first-diffContext, 0)
+		hi := min(last+diffContext+1, len(ops))
+
+		oldStart, newStart := 1, 1
+		for _, l := range ops[:lo] {
+			if l.op != '+' {
+				oldStart++
+			}
+			if l.op != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, l := range ops[lo:hi] {
+			if l.op != '+' {
+				oldCount++
+			}
+			if l.op != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, l := range ops[lo:hi] {
+			sb.WriteByte(l.op)
+			sb.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = hi
+	}
+	return sb.String()
+}
@@ -0,0 +1,55 @@
+package apply
+
+import "strings"
+
+const utf8BOM = "\ufeff"
+
+// textFormat captures the byte-level conventions of a file so a patch can be
+// applied to normalized LF text and written back in the file's own style.
+type textFormat struct {
+	bom             bool
+	crlf            bool
+	trailingNewline bool
+	// empty files have no trailing newline to keep, so whatever is
+	// written into them keeps its own
+	empty bool
+}
+
+// detectTextFormat inspects existing file contents. CRLF wins when it is the
+// majority line ending, so a single stray LF doesn't flip the whole file.
+func detectTextFormat(data []byte) textFormat {
+	s := string(data)
+	crlf := strings.Count(s, "\r\n")
+	lf := strings.Count(s, "\n") - crlf
+	return textFormat{
+		bom:             strings.HasPrefix(s, utf8BOM),
+		crlf:            crlf > 0 && crlf >= lf,
+		trailingNewline: strings.HasSuffix(s, "\n"),
+		empty:           strings.TrimPrefix(s, utf8BOM) == "",
+	}
+}
+
+// normalize strips the BOM and converts CRLF line endings to LF.
+func (f textFormat) normalize(s string) string {
+	s = strings.TrimPrefix(s, utf8BOM)
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+// restore converts normalized text back to the recorded format.
+func (f textFormat) restore(s string) string {
+	s = f.normalize(s)
+	if f.empty {
+		// keep the newline state of the new text
+	} else if f.trailingNewline && !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	} else if !f.trailingNewline {
+		s = strings.TrimRight(s, "\n")
+	}
+	if f.crlf {
+		s = strings.ReplaceAll(s, "\n", "\r\n")
+	}
+	if f.bom {
+		s = utf8BOM + s
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package apply
+
+import (
+	"testing"
+
+	"goctx/internal/patch"
+)
+
+func TestTextFormatRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+	}{
+		{"LF", "a\nb\nc\n"},
+		{"CRLF", "a\r\nb\r\nc\r\n"},
+		{"BOM CRLF", utf8BOM + "a\r\nb\r\nc\r\n"},
+		{"No Trailing Newline", "a\nb\nc"},
+		{"BOM LF No Trailing Newline", utf8BOM + "a\nb\nc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := detectTextFormat([]byte(tt.original))
+			if got := f.restore(f.normalize(tt.original)); got != tt.original {
+				t.Errorf("round trip = %q, want %q", got, tt.original)
+			}
+		})
+	}
+}
+
+func TestSurgicalContentPreservesFormat(t *testing.T) {
+	original := utf8BOM + "first\r\nsecond\r\nthird"
+
+	hunks := []patch.Hunk{{Search: "second\nthird", Replace: "SECOND\nthird"}}
+	got, _, err := surgicalContent([]byte(original), hunks, patch.DefaultMatchOptions)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	want := utf8BOM + "first\r\nSECOND\r\nthird"
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFullFileContentPreservesFormat(t *testing.T) {
+	// Native dialect content arrives trimmed and LF-only
+	got := fullFileContent([]byte("old\r\nfile\r\n"), true, "new\nfile")
+	if string(got) != "new\r\nfile\r\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestFullFileContentIntoEmptyFile(t *testing.T) {
+	// An empty file has no newline convention; the new content keeps its own
+	for _, existing := range []string{"", utf8BOM} {
+		got := fullFileContent([]byte(existing), true, "package e\n")
+		if want := existing + "package e\n"; string(got) != want {
+			t.Errorf("into %q: got %q, want %q", existing, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package apply
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"goctx/internal/runner"
+)
+
+// builtinGofmt names the in-process go/format formatter in goctx.json.
+const builtinGofmt = "gofmt"
+
+// formatTimeout stops an external formatter that hangs, such as an npx
+// waiting for a prompt, so it cannot block the apply.
+const formatTimeout = 2 * time.Minute
+
+// formatChanges runs the configured formatter on every file the patch wrote
+// and records what each formatter changed in the report. Formatter failures
+// are logged but do not fail the patch; verification catches real breakage.
+// Rewrites go through the transaction's files, so rollback still restores
+// the pre-patch bytes. Cancelling ctx stops the formatter running and skips
+// the rest.
+func formatChanges(ctx context.Context, root string, changes []fileChange, formatters map[string]string, report *ApplyReport, onProgress ProgressFunc) {
+	logf := func(format string, args ...any) {
+		if onProgress != nil {
+			onProgress("", "", fmt.Sprintf(format, args...))
+		}
+	}
+
+	for _, c := range changes {
+		if ctx.Err() != nil {
+			logf("Formatting cancelled")
+			return
+		}
+		formatter := formatters[filepath.Ext(c.path)]
+		// Untouched files have no backup to roll back to, so leave them alone
+		if c.delete || c.unchanged() || formatter == "" {
+			continue
+		}
+
+		before, err := os.ReadFile(c.target)
+		if err != nil {
+			logf("Format skipped: %s: %v", c.path, err)
+			continue
+		}
+
+		after, err := runFormatter(ctx, root, c, before, formatter)
+		if err != nil {
+			logf("Format failed: %s: %v", c.path, err)
+			continue
+		}
+		if bytes.Equal(before, after) {
+			continue
+		}
+
+		diff := UnifiedDiff(c.path, before, after)
+		report.Files[c.report].Formatted = diff
+		logf("Formatted: %s", c.path)
+		for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+			logf("  %s", line)
+		}
+	}
+}
+
+// cancelledFormatting rolls back a patch whose formatting was cancelled,
+// so a stopped apply never leaves half-formatted files behind.
+func cancelledFormatting(tx *transaction) error {
+	if err := tx.rollback(); err != nil {
+		return fmt.Errorf("PATCH_ERROR: cancelled while formatting (rollback failed: %v)", err)
+	}
+	return fmt.Errorf("PATCH_ERROR: cancelled while formatting (workspace restored)")
+}
+
+// runFormatter formats one written file and returns its new content.
+// Formatters normalize line endings and drop byte order marks, so the
+// result is brought back to the file's own text format.
+func runFormatter(ctx context.Context, root string, c fileChange, data []byte, formatter string) ([]byte, error) {
+	formatted, onDisk := data, data
+	var err error
+	if formatter == builtinGofmt {
+		formatted, err = format.Source(data)
+	} else {
+		cmd := strings.ReplaceAll(formatter, "{{file}}", shellQuote(c.path))
+		ctx, cancel := context.WithTimeout(ctx, formatTimeout)
+		out, runErr := runner.RunContext(ctx, root, cmd, nil, nil)
+		cancel()
+		if runErr != nil {
+			return nil, fmt.Errorf("%s: %v\n%s", cmd, runErr, strings.TrimSpace(string(out)))
+		}
+		formatted, err = os.ReadFile(c.target)
+		onDisk = formatted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	formatted = []byte(detectTextFormat(data).restore(string(formatted)))
+	if !bytes.Equal(formatted, onDisk) {
+		if err := writeAtomic(c.target, formatted, c.mode); err != nil {
+			return nil, err
+		}
+	}
+	return formatted, nil
+}
+
+// shellQuote quotes s for sh so paths with spaces or quotes survive.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
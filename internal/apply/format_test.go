@@ -0,0 +1,133 @@
+package apply
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goctx/internal/model"
+)
+
+func TestApplyPatchFormatsGoFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"goctx.json": `{"formatters": {".go": "gofmt"}}`,
+		"main.go":    "package main\n\nfunc main() {\n}\n",
+		"notes.txt":  "x\n",
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"main.go":   "<<<<<< SEARCH\nfunc main() {\n}\n======\nfunc main() {\nx:=1\n_ = x\n}\n>>>>>> REPLACE",
+		"notes.txt": "y  =  1",
+	}}
+
+	var log []string
+	report, err := ApplyPatch(root, input, func(phase, desc, line string) {
+		if line != "" {
+			log = append(log, line)
+		}
+	})
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	assertFile(t, root, "main.go", "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n")
+	assertFile(t, root, "notes.txt", "y  =  1\n")
+
+	if f := report.Files[0]; !strings.Contains(f.Formatted, "+\tx := 1") {
+		t.Errorf("formatting diff should be reported separately, got %q", f.Formatted)
+	}
+	if f := report.Files[1]; f.Formatted != "" {
+		t.Errorf("files without a formatter should be left alone, got %q", f.Formatted)
+	}
+	if !strings.Contains(strings.Join(log, "\n"), "Formatted: main.go") {
+		t.Errorf("formatting should be logged, got %v", log)
+	}
+}
+
+func TestApplyPatchFormatKeepsLineEndings(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"goctx.json": `{"formatters": {".go": "gofmt", ".txt": "tr a-z A-Z < {{file}} | tr -d '\\r' > {{file}}.tmp && mv {{file}}.tmp {{file}}"}}`,
+		"main.go":    "\ufeffpackage main\r\n\r\nfunc main() {\r\n}\r\n",
+		"notes.txt":  "one\r\ntwo\r\n",
+	})
+
+	// format.Source and most external tools write LF without a BOM
+	input := model.ProjectOutput{Files: map[string]string{
+		"main.go":   "<<<<<< SEARCH\nfunc main() {\n}\n======\nfunc main() {\nx:=1\n_ = x\n}\n>>>>>> REPLACE",
+		"notes.txt": "<<<<<< SEARCH\ntwo\n======\nthree\n>>>>>> REPLACE",
+	}}
+	report, err := ApplyPatch(root, input, nil)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	assertFile(t, root, "main.go", "\ufeffpackage main\r\n\r\nfunc main() {\r\n\tx := 1\r\n\t_ = x\r\n}\r\n")
+	assertFile(t, root, "notes.txt", "ONE\r\nTHREE\r\n")
+	for _, f := range report.Files {
+		if strings.Contains(f.Formatted, "-\ufeff") || !strings.Contains(f.Formatted, "+") {
+			t.Errorf("%s: formatting diff should only show the formatter's changes, got %q", f.Path, f.Formatted)
+		}
+	}
+}
+
+func TestApplyPatchExternalFormatter(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"goctx.json": `{"formatters": {".txt": "tr a-z A-Z < {{file}} > {{file}}.tmp && mv {{file}}.tmp {{file}}"}}`,
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{"my notes.txt": "hello"}}
+	report, err := ApplyPatch(root, input, nil)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	assertFile(t, root, "my notes.txt", "HELLO")
+	if report.Files[0].Formatted == "" {
+		t.Error("external formatter changes should be reported")
+	}
+}
+
+func TestApplyPatchFormatterFailureIsNotFatal(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"goctx.json": `{"formatters": {".txt": "false {{file}}"}}`,
+	})
+
+	// A failing formatter is logged; the patch still applies as written
+	input := model.ProjectOutput{Files: map[string]string{"notes.txt": "hello"}}
+	var log []string
+	if _, err := ApplyPatch(root, input, func(phase, desc, line string) { log = append(log, line) }); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	assertFile(t, root, "notes.txt", "hello")
+	if !strings.Contains(strings.Join(log, "\n"), "Format failed: notes.txt") {
+		t.Errorf("formatter failure should be logged, got %v", log)
+	}
+}
+
+func TestApplyPatchCancelStopsFormatter(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"goctx.json": `{"formatters": {".txt": "sleep 30; true {{file}}"}}`,
+	})
+
+	// A hanging formatter must not outlive the caller's context
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	input := model.ProjectOutput{Files: map[string]string{"notes.txt": "hello"}}
+	start := time.Now()
+	_, err := ApplyPatchContext(ctx, root, input, nil, Options{})
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("cancelled apply took %v", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "cancelled while formatting") {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "notes.txt")); !os.IsNotExist(err) {
+		t.Errorf("cancelled apply should be rolled back, stat: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package apply
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"goctx/internal/baseline"
+	"goctx/internal/config"
+	"goctx/internal/git"
+	"goctx/internal/model"
+)
+
+// sandbox is a throwaway copy of the workspace a patch is verified in: a
+// detached git worktree carrying the workspace's uncommitted changes or,
+// outside git or before the first commit, a plain copy.
+type sandbox struct {
+	root    string    // the real workspace
+	tmp     string    // temporary directory holding the copy
+	dir     string    // the copy of root
+	repo    *git.Repo // the workspace's repository, when dir is in a worktree
+	tree    string    // top of the worktree; dir when root is the top
+	exclude []string  // patterns of paths not copied, see config.IsolatedExclude
+}
+
+func newSandbox(root string, exclude []string) (*sandbox, error) {
+	tmp, err := os.MkdirTemp("", "goctx-verify-")
+	if err != nil {
+		return nil, err
+	}
+	s := &sandbox{root: root, tmp: tmp, tree: filepath.Join(tmp, "tree"), exclude: exclude}
+	s.dir = s.tree
+
+	if repo, openErr := git.Open(root); openErr == nil && repo.AddWorktree(s.tree) == nil {
+		// The whole repository is checked out, so a workspace below its top
+		// keeps its place, and its go.mod or parent files, in the copy
+		s.repo = repo
+		s.dir = filepath.Join(s.tree, repo.Prefix)
+		err = s.syncChanges()
+	} else {
+		// Not a repository, or nothing committed to check out yet
+		err = copyTree(root, s.dir, s.skip)
+	}
+	if err != nil {
+		s.remove()
+		return nil, err
+	}
+	return s, nil
+}
+
+// skip reports whether a path, relative to the copied directory, stays out
+// of the sandbox. Exclusions match the way protected patterns do.
+func (s *sandbox) skip(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	return reservedMatch(rel) != "" || protectedBy(rel, s.exclude) != ""
+}
+
+// syncChanges brings the uncommitted state of the repository into the
+// worktree, which starts out at HEAD. Ignored files come along too, since
+// builds often need them: a .env, generated code or a vendor directory.
+func (s *sandbox) syncChanges() error {
+	changed, err := s.repo.ChangedFiles()
+	if err != nil {
+		return fmt.Errorf("list workspace changes: %w", err)
+	}
+	for _, rel := range changed {
+		src, dst := filepath.Join(s.repo.Root, rel), filepath.Join(s.tree, rel)
+		info, err := os.Lstat(src)
+		if os.IsNotExist(err) {
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// A nested repository or submodule
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := copyEntry(src, dst, info); err != nil {
+			return err
+		}
+	}
+
+	entries, err := s.repo.Status(git.StatusOptions{Ignored: true})
+	if err != nil {
+		return fmt.Errorf("list ignored files: %w", err)
+	}
+	for _, e := range entries {
+		// An ignored directory is listed once, with a trailing slash
+		rel := strings.TrimSuffix(e.Path, "/")
+		if !e.Ignored() || s.skip(rel) {
+			continue
+		}
+		src, dst := filepath.Join(s.repo.Root, rel), filepath.Join(s.tree, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		err := copyTree(src, dst, func(sub string) bool {
+			return s.skip(filepath.Join(rel, sub))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seeded reads the given paths of the sandbox as they were copied, before
+// anything is applied to it. A missing file is recorded as nil.
+func (s *sandbox) seeded(paths []string) (map[string][]byte, error) {
+	files := make(map[string][]byte, len(paths))
+	for _, rel := range paths {
+		data, err := os.ReadFile(filepath.Join(s.dir, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		files[rel] = data
+	}
+	return files, nil
+}
+
+// remove deletes the sandbox, unregistering the worktree.
+func (s *sandbox) remove() error {
+	var err error
+	if s.repo != nil {
+		err = s.repo.RemoveWorktree(s.tree)
+	}
+	if rmErr := os.RemoveAll(s.tmp); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// copyTree copies root, a file or a directory, to dst, leaving out the
+// paths skip reports. skip is given paths relative to root.
+func copyTree(root, dst string, skip func(rel string) bool) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && skip(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		return copyEntry(path, target, info)
+	})
+}
+
+// copyEntry copies a regular file with its permissions, or recreates a
+// symlink. Other file types are skipped.
+func copyEntry(src, dst string, info fs.FileInfo) error {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		os.Remove(dst)
+		return os.Symlink(link, dst)
+	case !info.Mode().IsRegular():
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+// applyIsolated applies and verifies a planned patch in a sandbox, and
+// writes it to the workspace only once it passed. A failing patch leaves
+// the workspace exactly as it was, uncommitted work included.
+func applyIsolated(ctx context.Context, root string, input model.ProjectOutput, cfg model.Config, opts Options, report *ApplyReport, touched []string, base *baseline.Baseline, onProgress ProgressFunc) (*ApplyReport, error) {
+	if onProgress != nil {
+		onProgress("Isolating", "Preparing a private copy of the workspace...", "")
+	}
+	box, err := newSandbox(root, config.IsolatedExclude(cfg))
+	if err != nil {
+		return report, fmt.Errorf("PATCH_ERROR: could not create an isolated copy: %w", err)
+	}
+	defer box.remove()
+	seeded, err := box.seeded(touched)
+	if err != nil {
+		return report, fmt.Errorf("PATCH_ERROR: could not read the isolated copy: %w", err)
+	}
+	if onProgress != nil {
+		kind := "copy"
+		if box.repo != nil {
+			kind = "git worktree"
+		}
+		onProgress("", "", fmt.Sprintf("Verifying in %s (%s)", box.dir, kind))
+	}
+
+	changes, _, err := planPatch(box.dir, input, newPlanOptions(cfg, opts))
+	if err != nil {
+		return report, fmt.Errorf("PATCH_ERROR: isolated copy differs from the workspace: %w", err)
+	}
+	if onProgress != nil {
+		onProgress("Applying", "Modifying the isolated copy...", "")
+	}
+	tx := &transaction{root: box.dir, description: input.ShortDescription}
+	if err := tx.commit(changes, nil); err != nil {
+		return report, fmt.Errorf("PATCH_ERROR: %w (workspace untouched)", err)
+	}
+	if len(cfg.Formatters) > 0 {
+		// Formatting is reported when the patch reaches the workspace
+		scratch := &ApplyReport{Files: make([]FileReport, len(report.Files))}
+		formatChanges(ctx, box.dir, changes, cfg.Formatters, scratch, nil)
+	}
+
+	if failed := verify(ctx, box.dir, cfg, touched, base, report, onProgress, opts.OnStep); failed != nil {
+		return report, verificationError(*failed, input.ShortDescription, func(string) string {
+			return "\n\nVerified in isolation; the workspace was not touched."
+		})
+	}
+
+	// What was verified is only what gets written if the touched files
+	// still hold the bytes the copy started from; the rest of the workspace
+	// may have moved on, so the patch is planned again against it.
+	if onProgress != nil {
+		onProgress("Replaying", "Applying the verified patch to the workspace...", "")
+	}
+	for _, rel := range touched {
+		data, err := os.ReadFile(filepath.Join(root, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return report, fmt.Errorf("PATCH_ERROR: %w (workspace untouched)", err)
+		}
+		if was := seeded[rel]; !bytes.Equal(data, was) || (data == nil) != (was == nil) {
+			return report, fmt.Errorf("PATCH_ERROR: %s differs from the verified copy; the workspace changed during verification and was left untouched", rel)
+		}
+	}
+	changes, replayed, err := planPatch(root, input, newPlanOptions(cfg, opts))
+	if err != nil {
+		return replayed, fmt.Errorf("PATCH_ERROR: workspace changed during verification: %w", err)
+	}
+	replayed.Tests, replayed.Baseline = report.Tests, report.Baseline
+
+	tx = &transaction{root: root, description: input.ShortDescription}
+	if err := tx.commit(changes, onProgress); err != nil {
+		if rbErr := tx.rollback(); rbErr != nil {
+			return replayed, fmt.Errorf("PATCH_ERROR: %w (rollback failed: %v)", err, rbErr)
+		}
+		return replayed, fmt.Errorf("PATCH_ERROR: %w (workspace restored)", err)
+	}
+	if len(cfg.Formatters) > 0 {
+		if onProgress != nil {
+			onProgress("Formatting", "Running formatters on touched files...", "")
+		}
+		formatChanges(ctx, root, changes, cfg.Formatters, replayed, onProgress)
+		if ctx.Err() != nil {
+			return replayed, cancelledFormatting(tx)
+		}
+	}
+	return replayed, nil
+}
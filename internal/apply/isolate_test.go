@@ -0,0 +1,150 @@
+package apply
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goctx/internal/model"
+)
+
+// newIsolatedRepo commits a.txt and b.txt, then leaves an uncommitted edit
+// to b.txt and an untracked c.txt, as a developer mid-task would.
+func newIsolatedRepo(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Chdir(t.TempDir())
+	writeFiles(t, ".", map[string]string{
+		"a.txt": "alpha\n",
+		"b.txt": "beta\n",
+		// The sandbox must see the uncommitted work, or verification lies
+		"goctx.json": `{"isolated": true, "scripts": [
+			{"name": "test", "run": "test -f c.txt && grep -q local b.txt && ! grep -q BROKEN a.txt"}
+		]}`,
+	})
+	runGit(t, "init", "-q")
+	runGit(t, "add", ".")
+	runGit(t, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-qm", "init")
+	writeFiles(t, ".", map[string]string{"b.txt": "beta local\n", "c.txt": "untracked\n"})
+}
+
+func runGit(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func TestApplyIsolatedFailureLeavesWorkspaceAlone(t *testing.T) {
+	newIsolatedRepo(t)
+
+	input := model.ProjectOutput{Files: map[string]string{"a.txt": "BROKEN\n"}}
+	_, err := ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "TEST_FAILURE") || !strings.Contains(err.Error(), "workspace was not touched") {
+		t.Fatalf("expected an isolated test failure, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "alpha\n")
+	assertFile(t, ".", "b.txt", "beta local\n")
+	assertFile(t, ".", "c.txt", "untracked\n")
+	if stashes := runGit(t, "stash", "list"); stashes != "" {
+		t.Errorf("isolated mode must not stash, got %q", stashes)
+	}
+	if trees := runGit(t, "worktree", "list"); strings.Count(trees, "\n") != 1 {
+		t.Errorf("temporary worktree left behind:\n%s", trees)
+	}
+}
+
+func TestApplyIsolatedReplaysVerifiedPatch(t *testing.T) {
+	newIsolatedRepo(t)
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.txt": "<<<<<< SEARCH\nalpha\n======\nALPHA\n>>>>>> REPLACE",
+		"d.txt": "new\n",
+	}}
+	var phases []string
+	report, err := ApplyPatch(".", input, func(phase, desc, line string) {
+		if phase != "" {
+			phases = append(phases, phase)
+		}
+	})
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if !report.OK() || len(report.Files) != 2 {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+	assertFile(t, ".", "a.txt", "ALPHA\n")
+	assertFile(t, ".", "b.txt", "beta local\n")
+	assertFile(t, ".", "d.txt", "new\n")
+	if got := strings.Join(phases, ","); !strings.Contains(got, "Isolating") || !strings.HasSuffix(got, "Test,Replaying") {
+		t.Errorf("unexpected phases %s", got)
+	}
+}
+
+func TestApplyIsolatedCopiesTreeOutsideGit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, ".", map[string]string{
+		"a.txt":      "alpha\n",
+		"sub/b.txt":  "beta\n",
+		"goctx.json": `{"scripts": [{"name": "test", "run": "test -f sub/b.txt && ! grep -q BROKEN a.txt"}]}`,
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{"a.txt": "BROKEN\n"}}
+	if _, err := ApplyPatchWithOptions(".", input, nil, Options{Isolated: true}); err == nil || !strings.Contains(err.Error(), "TEST_FAILURE") {
+		t.Fatalf("expected a test failure, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "alpha\n")
+
+	input = model.ProjectOutput{Files: map[string]string{"a.txt": "ALPHA\n"}}
+	if _, err := ApplyPatchWithOptions(".", input, nil, Options{Isolated: true}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	assertFile(t, ".", "a.txt", "ALPHA\n")
+}
+
+func TestApplyIsolatedCopiesIgnoredFiles(t *testing.T) {
+	newIsolatedRepo(t)
+	writeFiles(t, ".", map[string]string{
+		".gitignore":              ".env\nvendor/\nnode_modules/\n",
+		".env":                    "TOKEN=1\n",
+		"vendor/lib/lib.go":       "package lib\n",
+		"node_modules/x/index.js": "",
+		"goctx.json": `{"isolated": true, "scripts": [
+			{"name": "test", "run": "test -f .env && test -f vendor/lib/lib.go && test ! -e node_modules"}
+		]}`,
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{"a.txt": "ALPHA\n"}}
+	if _, err := ApplyPatch(".", input, nil); err != nil {
+		t.Fatalf("ignored files the build needs should reach the copy: %v", err)
+	}
+	assertFile(t, ".", "a.txt", "ALPHA\n")
+}
+
+func TestApplyIsolatedRejectsWorkspaceChangedDuringVerification(t *testing.T) {
+	newIsolatedRepo(t)
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The step edits the real a.txt, as a developer saving mid-verification
+	// would
+	writeFiles(t, ".", map[string]string{
+		"goctx.json": `{"isolated": true, "scripts": [
+			{"name": "test", "run": "echo edited > ` + filepath.Join(root, "a.txt") + `"}
+		]}`,
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{"a.txt": "edited\n"}}
+	_, err = ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "a.txt differs from the verified copy") {
+		t.Fatalf("expected the replay to be refused, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "edited\n")
+}
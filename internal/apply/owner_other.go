@@ -0,0 +1,10 @@
+//go:build !unix
+
+package apply
+
+import "os"
+
+// preserveOwner is a no-op where files have no unix owner.
+func preserveOwner(path string, like os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package apply
+
+import (
+	"os"
+	"syscall"
+)
+
+// preserveOwner gives path the owner and group recorded in like. Only root may
+// hand a file to another user, so a failure here is worth reporting but not
+// worth aborting the patch over.
+func preserveOwner(path string, like os.FileInfo) error {
+	want, ok := like.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if got, ok := info.Sys().(*syscall.Stat_t); ok && got.Uid == want.Uid && got.Gid == want.Gid {
+		return nil
+	}
+	return os.Lchown(path, int(want.Uid), int(want.Gid))
+}
@@ -0,0 +1,61 @@
+package apply
+
+import (
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+
+	"goctx/internal/pathsafe"
+	"goctx/internal/trash"
+)
+
+// reservedPaths may never be written by a patch: git's own data and the
+// trash that backs up deleted files.
+var reservedPaths = []string{".git", trash.Dir}
+
+// resolvePath validates a path named by a patch and returns it relative to
+// root in slash form; see pathsafe.Resolve. The reserved paths are refused
+// too.
+func resolvePath(root, path string) (string, error) {
+	return pathsafe.Resolve(root, path, reservedPaths...)
+}
+
+// protectedBy returns the first pattern that protects rel, or "". A pattern
+// without a slash matches any single path element, so "keys.json" covers
+// nested copies and ".github" everything below it. A pattern with a slash
+// matches the path or one of its parent directories. A trailing "/" or "/**"
+// is ignored.
+func protectedBy(rel string, patterns []string) string {
+	elems := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		p := strings.TrimSuffix(strings.TrimSuffix(filepath.ToSlash(pattern), "/**"), "/")
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			for _, elem := range elems {
+				if ok, _ := pathpkg.Match(p, elem); ok {
+					return pattern
+				}
+			}
+			continue
+		}
+		for i := range elems {
+			if ok, _ := pathpkg.Match(p, strings.Join(elems[:i+1], "/")); ok {
+				return pattern
+			}
+		}
+	}
+	return ""
+}
+
+// safePath reports whether path may be written by a patch applied at root.
+func safePath(root, path string) bool {
+	_, err := resolvePath(root, path)
+	return err == nil
+}
+
+// reservedMatch returns the reserved path rel falls under, or "".
+func reservedMatch(rel string) string {
+	return pathsafe.Reserved(rel, reservedPaths...)
+}
@@ -0,0 +1,227 @@
+package apply
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"goctx/internal/model"
+	"goctx/internal/pathsafe"
+)
+
+// newPathRoot creates a project root next to a sibling whose name shares its
+// prefix, with symlinks pointing inside and outside the project.
+func newPathRoot(t testing.TB) string {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "proj")
+	writeFiles(t, root, map[string]string{"src/a.go": "package src\n"})
+	writeFiles(t, base, map[string]string{"proj-evil/x.go": "package evil\n"})
+	if err := os.Symlink(filepath.Join(base, "proj-evil"), filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("src", filepath.Join(root, "alias")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestResolvePath(t *testing.T) {
+	root := newPathRoot(t)
+
+	tests := []struct {
+		path string
+		want string // "" means rejected
+	}{
+		{"src/a.go", "src/a.go"},
+		{"./src/new/b.go", "src/new/b.go"},
+		{filepath.Join(root, "src/a.go"), "src/a.go"},
+		{"alias/a.go", "alias/a.go"},
+		{filepath.Join(root+"-evil", "x.go"), ""},
+		{"../proj-evil/x.go", ""},
+		{"src/../../proj-evil/x.go", ""},
+		{"escape/x.go", ""},
+		{"escape/new/x.go", ""},
+		{".git/config", ""},
+		{"vendor/lib/.git/HEAD", ""},
+		{".trash/index.json", ""},
+		{".", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := resolvePath(root, tt.path)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("resolvePath(%q) = %q, want rejection", tt.path, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolvePath(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestApplyPatchReportsRejectedPaths(t *testing.T) {
+	root := newPathRoot(t)
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"src/a.go":    "package src\n\nfunc A() {}",
+		"escape/x.go": "package pwned",
+	}}
+	report, err := ApplyPatch(root, input, nil)
+	if err == nil || !strings.Contains(err.Error(), "escape/x.go") {
+		t.Fatalf("expected the rejected path in the error, got %v", err)
+	}
+
+	var rejected *FileReport
+	for i := range report.Files {
+		if report.Files[i].Path == "escape/x.go" {
+			rejected = &report.Files[i]
+		}
+	}
+	if rejected == nil || rejected.OK() || rejected.Action != "reject" {
+		t.Fatalf("rejected path should be reported, got %+v", report.Files)
+	}
+
+	// Nothing is written when any path is rejected
+	assertFile(t, root, "src/a.go", "package src\n")
+	assertFile(t, filepath.Dir(root), "proj-evil/x.go", "package evil\n")
+}
+
+func TestApplyPatchResolvesAgainstRoot(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.go": "alpha\n"})
+	t.Chdir(t.TempDir())
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.go": "<<<<<< SEARCH\nalpha\n======\nbeta\n>>>>>> REPLACE",
+	}}
+	if _, err := ApplyPatch(root, input, nil); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	assertFile(t, root, "a.go", "beta\n")
+}
+
+func FuzzResolvePath(f *testing.F) {
+	for _, seed := range []string{
+		"src/a.go", "../x", "a/../../b", "/etc/passwd", ".git/config", "escape/x",
+		"alias/../escape/y", "./.trash/x", "a//b", `a\..\b`, "\x00", "proj-evil/x.go",
+	} {
+		f.Add(seed)
+	}
+	root := newPathRoot(f)
+
+	f.Fuzz(func(t *testing.T, path string) {
+		rel, err := resolvePath(root, path)
+		if err != nil {
+			return
+		}
+		for _, elem := range strings.Split(rel, "/") {
+			if elem == ".." || elem == ".git" {
+				t.Fatalf("resolvePath(%q) = %q contains %q", path, rel, elem)
+			}
+		}
+		if reservedMatch(rel) != "" {
+			t.Fatalf("resolvePath(%q) = %q is reserved", path, rel)
+		}
+
+		realRoot, _ := filepath.EvalSymlinks(root)
+		real, err := pathsafe.EvalExisting(filepath.Join(root, rel))
+		if err != nil {
+			t.Fatalf("accepted %q but it cannot be resolved: %v", path, err)
+		}
+		if r, err := filepath.Rel(realRoot, real); err != nil || !pathsafe.IsLocal(r) || r == "." {
+			t.Fatalf("resolvePath(%q) = %q escapes the root via %q", path, rel, real)
+		}
+	})
+}
+
+func TestProtectedBy(t *testing.T) {
+	patterns := []string{"keys.json", ".github", "deploy/*.yml", "secrets/**", "*.pem"}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"keys.json", "keys.json"},
+		{"tools/keys.json", "keys.json"},
+		{".github/workflows/ci.yml", ".github"},
+		{"deploy/prod.yml", "deploy/*.yml"},
+		{"deploy/prod.yml.bak", ""},
+		{"secrets/db/password", "secrets/**"},
+		{"certs/server.pem", "*.pem"},
+		{"keys.json.example", ""},
+		{"internal/keys/keys.go", ""},
+	}
+	for _, tt := range tests {
+		if got := protectedBy(tt.path, patterns); got != tt.want {
+			t.Errorf("protectedBy(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPatchProtectedNeedsConfirmation(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"goctx.json": `{"scripts": {"build": "true"}}`,
+		"main.go":    "package main\n",
+	})
+
+	input := model.ProjectOutput{
+		Renames: map[string]string{"cmd/main.go": "main.go"},
+		Files: map[string]string{
+			"goctx.json":  `{"scripts": {"build": "exit 0"}}`,
+			"cmd/main.go": "",
+		},
+	}
+	if got := mustCheck(t, root, input).ProtectedFiles(); len(got) != 1 || got[0] != "goctx.json" {
+		t.Fatalf("ProtectedFiles = %v, want [goctx.json]", got)
+	}
+
+	report, err := ApplyPatch(root, input, nil)
+	if err == nil || !strings.Contains(err.Error(), "protected") {
+		t.Fatalf("expected protected rejection, got %v", err)
+	}
+	if f := report.Files[1]; !f.Protected || f.Diff == "" {
+		t.Errorf("protected file should be flagged with its diff: %+v", f)
+	}
+	assertFile(t, root, "goctx.json", `{"scripts": {"build": "true"}}`)
+
+	if _, err := ApplyPatchWithOptions(root, input, nil, Options{AllowProtected: []string{"goctx.json"}}); err != nil {
+		t.Fatalf("confirmed apply failed: %v", err)
+	}
+	assertFile(t, root, "goctx.json", `{"scripts": {"build": "exit 0"}}`)
+}
+
+func TestApplyPatchProtectedFromConfig(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"goctx.json":       `{"protected": ["migrations", "!go.mod"]}`,
+		"migrations/1.sql": "create table a;\n",
+		"keys.json":        "{}\n",
+	})
+
+	// The list adds to the defaults and "!go.mod" drops one of them. A
+	// protected file whose hunk fails still needs confirmation.
+	input := model.ProjectOutput{Files: map[string]string{
+		"migrations/1.sql": "drop table a;",
+		"go.mod":           "module x",
+		"keys.json":        "<<<<<< SEARCH\nmissing\n======\nfound\n>>>>>> REPLACE",
+	}}
+	got := mustCheck(t, root, input).ProtectedFiles()
+	if want := []string{"keys.json", "migrations/1.sql"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProtectedFiles = %v, want %v", got, want)
+	}
+
+	report := mustCheck(t, root, input)
+	if f := report.Files[1]; f.Path != "keys.json" || f.NeedsConfirm || !f.Blocked() || strings.Contains(f.Error, "protected") {
+		t.Errorf("the hunk failure should be reported over the protection: %+v", f)
+	}
+	if f := report.Files[2]; !f.Protected || !f.NeedsConfirm || f.Blocked() {
+		t.Errorf("migrations/1.sql only needs confirmation: %+v", f)
+	}
+}
@@ -0,0 +1,166 @@
+package apply
+
+import (
+	"fmt"
+	"strings"
+
+	"goctx/internal/baseline"
+	"goctx/internal/gotest"
+	"goctx/internal/patch"
+	"goctx/internal/stash"
+)
+
+// HunkStatus is the outcome of matching a single SEARCH/REPLACE hunk.
+type HunkStatus string
+
+const (
+	HunkExact          HunkStatus = "exact"
+	HunkFuzzy          HunkStatus = "fuzzy"
+	HunkAmbiguous      HunkStatus = "ambiguous"
+	HunkNotFound       HunkStatus = "not found"
+	HunkAlreadyApplied HunkStatus = "already applied"
+)
+
+// Failed reports whether the status prevents the hunk from applying.
+func (s HunkStatus) Failed() bool {
+	return s == HunkAmbiguous || s == HunkNotFound
+}
+
+// HunkReport describes how one hunk matched. Match is where it landed; for
+// failed hunks NearMiss is the closest region in the file, to show the
+// reviewer what the AI was probably aiming at.
+type HunkReport struct {
+	Index        int          `json:"index"`
+	Status       HunkStatus   `json:"status"`
+	Match        *patch.Match `json:"match,omitempty"`
+	NearMiss     *patch.Match `json:"near_miss,omitempty"`
+	NearMissText string       `json:"near_miss_text,omitempty"`
+}
+
+// FileReport is the outcome for one file of a patch.
+type FileReport struct {
+	Path   string       `json:"path"`
+	Action string       `json:"action"` // "create", "modify", "delete", "already deleted", "rename" or "reject"
+	Error  string       `json:"error,omitempty"`
+	Hunks  []HunkReport `json:"hunks,omitempty"`
+	Diff   string       `json:"diff,omitempty"`
+	Mode   string       `json:"mode,omitempty"` // permission change, e.g. "0644 -> 0755"
+	// Protected is set when the file matches a protected pattern and was not
+	// confirmed. Unless the file also fails otherwise, the error then only
+	// asks for confirmation.
+	Protected bool `json:"protected,omitempty"`
+	// NeedsConfirm is set when confirming the protected file is all it
+	// takes for it to apply.
+	NeedsConfirm bool `json:"needsConfirm,omitempty"`
+	// Formatted is the diff a formatter applied on top of the patch.
+	Formatted string `json:"formatted,omitempty"`
+}
+
+// OK reports whether the file applies cleanly.
+func (f FileReport) OK() bool {
+	return f.Error == ""
+}
+
+// Blocked reports whether the file fails for a reason confirming it would
+// not fix.
+func (f FileReport) Blocked() bool {
+	return !f.OK() && !f.NeedsConfirm
+}
+
+// FileError returns the error when no failed hunk already explains it, such
+// as a syntax error in the patched result or a rejected path.
+func (f FileReport) FileError() string {
+	for _, h := range f.Hunks {
+		if h.Status.Failed() {
+			return ""
+		}
+	}
+	return f.Error
+}
+
+// ApplyReport lists every file and hunk of a patch with its outcome. It is
+// produced by both Check and ApplyPatch so the GUI and CLI show the same thing.
+type ApplyReport struct {
+	Files []FileReport `json:"files"`
+	// Tests holds per-test results of the last verification step that ran
+	// `go test -json`.
+	Tests *gotest.Report `json:"tests,omitempty"`
+	// Baseline compares each verification step with its run on the
+	// unpatched workspace, when a baseline was captured.
+	Baseline []baseline.Comparison `json:"baseline,omitempty"`
+	// Recovery is the stash holding the touched files as the failed patch
+	// left them, before they were restored; see stash.Recover.
+	Recovery *stash.Entry `json:"recovery,omitempty"`
+
+	confirmScore float64 // matching.confirm_score the patch was planned with
+}
+
+// HasDetails reports whether the report says more than that the patch
+// applied: a failed file, test results or a baseline comparison.
+func (r *ApplyReport) HasDetails() bool {
+	return r != nil && (!r.OK() || r.Tests != nil || len(r.Baseline) > 0)
+}
+
+// OK reports whether every file in the patch applies cleanly.
+func (r *ApplyReport) OK() bool {
+	if r == nil {
+		return false
+	}
+	for _, f := range r.Files {
+		if !f.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the report as plain text, one line per file and hunk.
+func (r *ApplyReport) String() string {
+	if r == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, f := range r.Files {
+		state := "OK"
+		if !f.OK() {
+			state = "FAILED"
+		}
+		if f.Mode != "" {
+			fmt.Fprintf(&sb, "%s %s (%s, mode %s)\n", state, f.Path, f.Action, f.Mode)
+		} else {
+			fmt.Fprintf(&sb, "%s %s (%s)\n", state, f.Path, f.Action)
+		}
+		for _, h := range f.Hunks {
+			fmt.Fprintf(&sb, "  hunk %d: %s", h.Index, h.Status)
+			if h.Match != nil {
+				fmt.Fprintf(&sb, " at lines %d-%d", h.Match.Start+1, h.Match.End)
+				if h.Status == HunkFuzzy {
+					fmt.Fprintf(&sb, " (%.0f%% confidence)", h.Match.Score*100)
+				}
+			}
+			if h.NearMiss != nil {
+				fmt.Fprintf(&sb, "; closest region lines %d-%d (%.0f%% similar)", h.NearMiss.Start+1, h.NearMiss.End, h.NearMiss.Score*100)
+			}
+			sb.WriteString("\n")
+		}
+		if msg := f.FileError(); msg != "" {
+			fmt.Fprintf(&sb, "  %s\n", msg)
+		}
+		if f.Formatted != "" {
+			sb.WriteString("  reformatted after patching\n")
+		}
+	}
+	for _, c := range r.Baseline {
+		fmt.Fprintf(&sb, "Baseline %s\n", c)
+		for _, f := range c.New {
+			fmt.Fprintf(&sb, "  new: %s\n", f)
+		}
+	}
+	if r.Tests != nil {
+		fmt.Fprintf(&sb, "Tests: %s\n", r.Tests.Summary())
+		for _, t := range r.Tests.Failures() {
+			fmt.Fprintf(&sb, "  FAIL %s (%.2fs)\n", t.Name(), t.Elapsed.Seconds())
+		}
+	}
+	return sb.String()
+}
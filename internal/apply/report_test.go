@@ -0,0 +1,72 @@
+package apply
+
+import (
+	"strings"
+	"testing"
+
+	"goctx/internal/model"
+	"goctx/internal/patch"
+)
+
+func TestApplyHunksReportsEveryHunk(t *testing.T) {
+	original := "func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 2\n}\n"
+	hunks := []patch.Hunk{
+		{Search: "func a() {\n\treturn 3\n}", Replace: "x"},
+		{Search: "\treturn 2", Replace: "\treturn 20"},
+		{Search: "x := 1\ny := 2", Replace: "z"},
+	}
+
+	_, reports, err := ApplyHunksWithOptions(original, hunks, MatchOptions(model.Config{Matching: model.Matching{Strict: true}}))
+	if err == nil || !strings.Contains(err.Error(), "hunk 1 of 3") {
+		t.Fatalf("expected first failure to be hunk 1, got %v", err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("expected a report for every hunk, got %d", len(reports))
+	}
+	if reports[0].Status != HunkNotFound || reports[0].NearMiss == nil || reports[0].NearMiss.Start != 0 {
+		t.Errorf("hunk 1 should be not found with a near miss at line 1, got %+v", reports[0])
+	}
+	if !strings.Contains(reports[0].NearMissText, "return 1") {
+		t.Errorf("near miss text should show the closest region, got %q", reports[0].NearMissText)
+	}
+	if reports[1].Status != HunkExact {
+		t.Errorf("hunk 2 should still be checked after hunk 1 failed, got %s", reports[1].Status)
+	}
+	if reports[2].Status != HunkNotFound {
+		t.Errorf("hunk 3 should be not found, got %s", reports[2].Status)
+	}
+}
+
+func TestApplyHunksReportsAmbiguous(t *testing.T) {
+	original := "x := 1\ny := 2\n\nx := 1\ny := 2\n"
+	hunks := []patch.Hunk{{Search: "x := 1\ny := 3", Replace: "z"}}
+
+	_, reports, err := ApplyHunksWithOptions(original, hunks, patch.MatchOptions{MinScore: 0.5})
+	if err == nil {
+		t.Fatal("expected ambiguous hunk to fail")
+	}
+	if reports[0].Status != HunkAmbiguous {
+		t.Errorf("expected ambiguous status, got %s", reports[0].Status)
+	}
+}
+
+func TestApplyPatchTwiceReportsAlreadyApplied(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"main.go": "package main\n\nfunc a() {}\n"})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"main.go": "<<<<<< SEARCH\nfunc a() {}\n======\nfunc a() {}\n\nfunc b() {}\n>>>>>> REPLACE",
+	}}
+	if _, err := ApplyPatch(".", input, nil); err != nil {
+		t.Fatalf("first apply failed: %v", err)
+	}
+	report, err := ApplyPatch(".", input, nil)
+	if err != nil {
+		t.Fatalf("second apply should succeed, got %v", err)
+	}
+	if got := report.Files[0].Hunks[0].Status; got != HunkAlreadyApplied {
+		t.Errorf("hunk status = %q, want %q", got, HunkAlreadyApplied)
+	}
+	assertFile(t, ".", "main.go", "package main\n\nfunc a() {}\n\nfunc b() {}\n")
+}
@@ -0,0 +1,555 @@
+package apply
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"goctx/internal/config"
+	"goctx/internal/git"
+	"goctx/internal/model"
+	"goctx/internal/patch"
+	"goctx/internal/trash"
+)
+
+// fileChange is one workspace mutation, fully computed in memory before
+// anything is written.
+type fileChange struct {
+	path     string // as named in the patch, relative to root
+	target   string // location on disk
+	from     string // for a move, the path it is moved from
+	source   string // for a move, the location on disk it is moved from
+	exists   bool
+	original []byte
+	content  []byte
+	delete   bool
+	hunks    []HunkReport
+	oldMode  os.FileMode // permissions before the patch, if the file exists
+	mode     os.FileMode // permissions to write the file with
+	report   int         // index of the file's entry in the ApplyReport
+}
+
+// planOptions are the goctx.json settings that decide whether a patch applies.
+type planOptions struct {
+	match               patch.MatchOptions
+	confirmScore        float64
+	requireDeleteMarker bool
+	protected           []string
+	allowProtected      map[string]bool // protected paths confirmed for this apply
+}
+
+func newPlanOptions(cfg model.Config, opts Options) planOptions {
+	po := planOptions{
+		match:               MatchOptions(cfg),
+		confirmScore:        cfg.Matching.ConfirmScore,
+		requireDeleteMarker: cfg.RequireDeleteMarker,
+		protected:           config.Protected(cfg),
+		allowProtected:      make(map[string]bool),
+	}
+	for _, path := range opts.AllowProtected {
+		po.allowProtected[filepath.ToSlash(filepath.Clean(path))] = true
+	}
+	return po
+}
+
+// protection returns the pattern protecting a change, or "" if it may be
+// written. Moving a protected file away counts as changing it.
+func (o planOptions) protection(path string, c fileChange) string {
+	if o.allowProtected[path] || o.allowProtected[c.path] {
+		return ""
+	}
+	if pattern := protectedBy(c.path, o.protected); pattern != "" {
+		return pattern
+	}
+	if c.from != "" {
+		return protectedBy(c.from, o.protected)
+	}
+	return ""
+}
+
+// planPatch validates every file in input against the current workspace and
+// computes the resulting contents without touching disk. Changes are sorted
+// by path so a patch always applies in the same order. The report covers
+// every file even when some fail; the error describes the first failure.
+func planPatch(root string, input model.ProjectOutput, opts planOptions) ([]fileChange, *ApplyReport, error) {
+	var changes []fileChange
+	report := &ApplyReport{confirmScore: opts.confirmScore}
+	var firstErr error
+
+	for _, path := range sortedPaths(input) {
+		rel, from, err := resolvePaths(root, path, input.Renames[path])
+		if err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+			report.Files = append(report.Files, FileReport{Path: path, Action: "reject", Error: err.Error()})
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		mode, hasMode := input.Modes[path]
+		change, err := planFile(root, rel, from, input.Files[path], mode, hasMode, opts)
+
+		change.report = len(report.Files)
+		fr := FileReport{Path: path, Hunks: change.hunks, Mode: change.modeChange()}
+		switch {
+		case change.delete && !change.exists:
+			fr.Action = "already deleted"
+		case change.delete:
+			fr.Action = "delete"
+		case change.from != "":
+			fr.Action = "rename"
+		case change.exists:
+			fr.Action = "modify"
+		default:
+			fr.Action = "create"
+		}
+
+		if err == nil {
+			// Rejected content still gets a diff so the reviewer can judge it
+			fr.Diff = change.diff()
+			err = change.validate()
+		}
+		// Protection is reported even for a failing file, so confirming
+		// once covers the corrected patch too
+		if pattern := opts.protection(path, change); pattern != "" {
+			fr.Protected = true
+			if err == nil {
+				fr.NeedsConfirm = true
+				err = fmt.Errorf("%s: protected path (matches %q); confirm to change it", path, pattern)
+			}
+		}
+
+		if err != nil {
+			fr.Error = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			changes = append(changes, change)
+		}
+		report.Files = append(report.Files, fr)
+	}
+
+	if firstErr != nil {
+		return nil, report, firstErr
+	}
+	return changes, report, nil
+}
+
+// resolvePaths validates a patch entry's path and, for a move, its source.
+func resolvePaths(root, path, from string) (string, string, error) {
+	rel, err := resolvePath(root, path)
+	if err != nil || from == "" {
+		return rel, "", err
+	}
+	fromRel, err := resolvePath(root, from)
+	if err != nil {
+		return "", "", fmt.Errorf("moved from %s: %w", from, err)
+	}
+	return rel, fromRel, nil
+}
+
+// planFile computes the change for a single patch entry. A non-empty from
+// moves that file to path before content is applied to it. With hasMode,
+// mode is the permission set requested by the patch; otherwise existing
+// files keep theirs. On a hunk failure the returned change still carries the
+// report of every hunk.
+func planFile(root, path, from, content string, mode os.FileMode, hasMode bool, opts planOptions) (fileChange, error) {
+	change := fileChange{path: path, target: filepath.Join(root, path), mode: defaultFileMode}
+
+	source := change.target
+	if from != "" {
+		change.from, change.source = from, filepath.Join(root, from)
+		source = change.source
+		if _, err := os.Lstat(change.target); err == nil {
+			return change, fmt.Errorf("%s: cannot move %s here, the file already exists", path, from)
+		}
+	}
+
+	existing, err := os.ReadFile(source)
+	change.exists = err == nil
+	change.original = existing
+	if err != nil && (from != "" || !os.IsNotExist(err)) {
+		return change, fmt.Errorf("%s: could not read file: %w", path, err)
+	}
+	if change.exists {
+		info, err := os.Stat(source)
+		if err != nil {
+			return change, fmt.Errorf("%s: could not stat file: %w", path, err)
+		}
+		change.oldMode = info.Mode().Perm()
+		change.mode = change.oldMode
+	}
+	if hasMode {
+		if mode&0400 == 0 {
+			// Neither the checks nor a later patch could read the file
+			return change, fmt.Errorf("%s: mode %04o would make the file unreadable", path, mode)
+		}
+		change.mode = mode
+	}
+
+	// With a mode directive or a move an empty body leaves the content alone.
+	if strings.TrimSpace(content) == "" && (hasMode || from != "") {
+		if !change.exists {
+			return change, fmt.Errorf("%s: mode change for missing file", path)
+		}
+		change.content = existing
+		if change.content == nil {
+			change.content = []byte{}
+		}
+		return change, nil
+	}
+
+	if isFileDeletion(content, opts.requireDeleteMarker) {
+		if from != "" {
+			return change, fmt.Errorf("%s: a moved file cannot also be deleted", path)
+		}
+		change.delete = true
+		return change, nil
+	}
+	if strings.TrimSpace(content) == "" {
+		return change, fmt.Errorf("%s: empty content; use %s to delete a file", path, patch.DeleteMarker)
+	}
+
+	if strings.Contains(content, "<<<<<< SEARCH") && strings.Contains(content, ">>>>>> REPLACE") {
+		hunks := patch.ParseHunks(content)
+		if len(hunks) == 0 {
+			return change, fmt.Errorf("%s: surgical markers found but failed to parse hunks", path)
+		}
+		if !change.exists {
+			return change, fmt.Errorf("%s: could not read file: %w", path, err)
+		}
+		change.content, change.hunks, err = surgicalContent(existing, hunks, opts.match)
+		if err != nil {
+			return change, fmt.Errorf("%s: %w", path, err)
+		}
+	} else {
+		change.content = fullFileContent(existing, change.exists, content)
+	}
+	return change, nil
+}
+
+// unchanged reports whether the file keeps its content and mode, as when
+// every hunk was already applied, the file is only moved, or a file to
+// delete is already gone.
+func (c fileChange) unchanged() bool {
+	if c.delete {
+		return !c.exists
+	}
+	return c.exists && bytes.Equal(c.original, c.content) && c.mode == c.oldMode
+}
+
+// validate rejects content that no longer parses. A file that was already
+// broken before the patch is let through, so fixing it stays possible.
+func (c fileChange) validate() error {
+	if c.delete || c.unchanged() {
+		return nil
+	}
+	err := validateContent(c.path, c.content)
+	if err == nil || (c.exists && validateContent(c.path, c.original) != nil) {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", c.path, err)
+}
+
+// diff renders the change as a git-style unified diff.
+func (c fileChange) diff() string {
+	var old, new []byte
+	if c.exists {
+		old = c.original
+	}
+	if !c.delete {
+		new = c.content
+	}
+	d := modeHeader(c)
+	if c.from != "" {
+		d += fmt.Sprintf("rename from %s\nrename to %s\n", c.from, c.path)
+		return d + unifiedDiff(c.from, c.path, old, new)
+	}
+	return d + UnifiedDiff(c.path, old, new)
+}
+
+// modeChange describes a permission change, e.g. "0644 -> 0755", or "" if
+// the file keeps its mode. New files only report a non-default mode.
+func (c fileChange) modeChange() string {
+	switch {
+	case c.delete:
+		return ""
+	case !c.exists && c.mode != defaultFileMode:
+		return fmt.Sprintf("%04o", c.mode)
+	case c.exists && c.mode != c.oldMode:
+		return fmt.Sprintf("%04o -> %04o", c.oldMode, c.mode)
+	}
+	return ""
+}
+
+// modeHeader renders a permission change the way git diff does.
+func modeHeader(c fileChange) string {
+	switch {
+	case c.delete:
+		return ""
+	case !c.exists && c.mode != defaultFileMode:
+		return fmt.Sprintf("new file mode 100%03o\n", c.mode)
+	case c.exists && c.mode != c.oldMode:
+		return fmt.Sprintf("old mode 100%03o\nnew mode 100%03o\n", c.oldMode, c.mode)
+	}
+	return ""
+}
+
+func sortedPaths(input model.ProjectOutput) []string {
+	paths := make([]string, 0, len(input.Files))
+	for path := range input.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// backup is the pre-patch state of one touched path.
+type backup struct {
+	target  string
+	existed bool
+	data    []byte
+	mode    os.FileMode
+	info    os.FileInfo // carries the owner to restore
+	trashed string      // trash entry ID of a deleted file, if it was trashed
+
+	// A moved file is put back from movedTo to movedFrom, both relative to
+	// the root, with git mv if that is how it was moved.
+	movedFrom, movedTo string
+	gitMoved           bool
+}
+
+// transaction writes planned changes and remembers the previous state of
+// every touched path, so rollback restores the exact previous bytes without
+// relying on git.
+type transaction struct {
+	root        string
+	description string // recorded with trashed files
+	backups     []backup
+	dirs        []string // directories created by the transaction, outermost first
+}
+
+func (tx *transaction) commit(changes []fileChange, onProgress ProgressFunc) error {
+	for _, c := range changes {
+		unchanged := c.unchanged()
+
+		if c.from != "" {
+			if err := tx.move(c, onProgress); err != nil {
+				return err
+			}
+		} else if unchanged {
+			// Nothing to do for a file whose hunks were all already applied
+			continue
+		}
+		if unchanged {
+			continue
+		}
+
+		b := backup{target: c.target}
+		if info, err := os.Stat(c.target); err == nil {
+			data, err := os.ReadFile(c.target)
+			if err != nil {
+				return fmt.Errorf("%s: could not snapshot file: %w", c.path, err)
+			}
+			b.existed, b.data, b.mode, b.info = true, data, info.Mode().Perm(), info
+		}
+
+		if c.delete {
+			if onProgress != nil {
+				onProgress("", fmt.Sprintf("Trashing: %s", c.path), "")
+			}
+			trashed, err := moveToTrash(tx.root, c.path, tx.description)
+			if err != nil {
+				return fmt.Errorf("%s: could not trash file: %w", c.path, err)
+			}
+			b.trashed = trashed
+			tx.backups = append(tx.backups, b)
+			continue
+		}
+
+		if err := tx.mkdirAll(filepath.Dir(c.target)); err != nil {
+			return fmt.Errorf("%s: could not create directory: %w", c.path, err)
+		}
+
+		if onProgress != nil {
+			onProgress("", "", fmt.Sprintf("Writing: %s", c.path))
+		}
+		// Record the backup before writing so a half-finished write is undone too.
+		tx.backups = append(tx.backups, b)
+		if err := writeAtomic(c.target, c.content, c.mode); err != nil {
+			return fmt.Errorf("%s: %w", c.path, err)
+		}
+		// Renaming the temp file into place resets the owner to ours
+		if b.existed {
+			if err := preserveOwner(c.target, b.info); err != nil && onProgress != nil {
+				onProgress("", "", fmt.Sprintf("Could not preserve owner of %s: %v", c.path, err))
+			}
+		}
+
+		written, err := os.ReadFile(c.target)
+		if err != nil || !bytes.Equal(written, c.content) {
+			return fmt.Errorf("%s: verification of written content failed", c.path)
+		}
+	}
+	return nil
+}
+
+// rollback restores every backed up path in reverse order and removes any
+// directories the transaction created. It keeps going after errors so as
+// much as possible is restored, and reports the first failure.
+func (tx *transaction) rollback() error {
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for i := len(tx.backups) - 1; i >= 0; i-- {
+		b := tx.backups[i]
+		switch {
+		case b.movedTo != "":
+			keep(tx.unmove(b))
+		case b.trashed != "":
+			_, err := trash.Restore(tx.root, b.trashed)
+			keep(err)
+		case b.existed:
+			if err := writeAtomic(b.target, b.data, b.mode); err != nil {
+				keep(err)
+				continue
+			}
+			keep(preserveOwner(b.target, b.info))
+		default:
+			if err := os.Remove(b.target); err != nil && !os.IsNotExist(err) {
+				keep(err)
+			}
+		}
+	}
+
+	for i := len(tx.dirs) - 1; i >= 0; i-- {
+		// Only empty directories are removed; anything else is left alone.
+		_ = os.Remove(tx.dirs[i])
+	}
+
+	tx.backups = nil
+	tx.dirs = nil
+	return firstErr
+}
+
+// move moves c.from to c.path, with git mv when git tracks the file so the
+// history follows it.
+func (tx *transaction) move(c fileChange, onProgress ProgressFunc) error {
+	if err := tx.mkdirAll(filepath.Dir(c.target)); err != nil {
+		return fmt.Errorf("%s: could not create directory: %w", c.path, err)
+	}
+	if onProgress != nil {
+		onProgress("", "", fmt.Sprintf("Moving: %s -> %s", c.from, c.path))
+	}
+
+	b := backup{target: c.source, existed: true, movedFrom: c.from, movedTo: c.path}
+	var err error
+	if repo, openErr := git.Open(tx.root); openErr == nil && repo.IsTracked(c.from) {
+		b.gitMoved = true
+		err = repo.Move(c.from, c.path)
+	} else {
+		err = os.Rename(c.source, c.target)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: could not move %s: %w", c.path, c.from, err)
+	}
+	tx.backups = append(tx.backups, b)
+	return nil
+}
+
+// unmove puts a moved file back where it came from.
+func (tx *transaction) unmove(b backup) error {
+	// git mv may have removed the emptied source directory
+	if err := os.MkdirAll(filepath.Dir(b.target), 0755); err != nil {
+		return err
+	}
+	if b.gitMoved {
+		repo, err := git.Open(tx.root)
+		if err != nil {
+			return err
+		}
+		return repo.Move(b.movedTo, b.movedFrom)
+	}
+	return os.Rename(filepath.Join(tx.root, b.movedTo), b.target)
+}
+
+// mkdirAll creates dir and records which directories did not exist before.
+// New directories get the permissions of their closest existing ancestor.
+func (tx *transaction) mkdirAll(dir string) error {
+	var missing []string
+	perm := os.FileMode(0755)
+	for d := dir; ; d = filepath.Dir(d) {
+		if info, err := os.Stat(d); err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", d)
+			}
+			perm = info.Mode().Perm()
+			break
+		}
+		missing = append([]string{d}, missing...)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	tx.dirs = append(tx.dirs, missing...)
+	return nil
+}
+
+// defaultFileMode is used for new files unless the patch requests a mode.
+const defaultFileMode os.FileMode = 0644
+
+// writeAtomic writes data to a temp file next to path and renames it into
+// place, so readers never observe a partially written file. A symlink is
+// written through to the file it points at, as os.WriteFile would, instead
+// of being replaced; resolvePath has checked that file is inside the root.
+func writeAtomic(path string, data []byte, mode os.FileMode) error {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("could not resolve path: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".goctx-*")
+	if err != nil {
+		return fmt.Errorf("could not create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	cleanup := func() { os.Remove(tmpName) }
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		cleanup()
+		return fmt.Errorf("could not write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		cleanup()
+		return fmt.Errorf("could not sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return fmt.Errorf("could not close temp file: %w", err)
+	}
+	if err := os.Chmod(tmpName, mode); err != nil {
+		cleanup()
+		return fmt.Errorf("could not set file mode: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		cleanup()
+		return fmt.Errorf("could not replace file: %w", err)
+	}
+	return nil
+}
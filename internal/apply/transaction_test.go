@@ -0,0 +1,627 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goctx/internal/model"
+	"goctx/internal/patch"
+	"goctx/internal/pipeline"
+	"goctx/internal/stash"
+	"goctx/internal/trash"
+)
+
+func writeFiles(t testing.TB, root string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertFile(t *testing.T, root, path, want string) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	if string(got) != want {
+		t.Errorf("%s = %q, want %q", path, got, want)
+	}
+}
+
+func TestApplyPatchValidatesAllHunksBeforeWriting(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"a.go": "alpha\n", "b.go": "beta\n", "c.go": "gamma\n"})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.go":     "<<<<<< SEARCH\nalpha\n======\nALPHA\n>>>>>> REPLACE",
+		"b.go":     "<<<<<< SEARCH\nbeta\n======\nBETA\n>>>>>> REPLACE",
+		"c.go":     "<<<<<< SEARCH\nmissing\n======\nnope\n>>>>>> REPLACE",
+		"new/d.go": "package d",
+	}}
+
+	_, err := ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "c.go: hunk 1 of 1") {
+		t.Fatalf("expected failure naming c.go hunk 1, got %v", err)
+	}
+
+	assertFile(t, ".", "a.go", "alpha\n")
+	assertFile(t, ".", "b.go", "beta\n")
+	if _, err := os.Stat("new"); !os.IsNotExist(err) {
+		t.Errorf("new directory should not have been created")
+	}
+}
+
+func TestApplyPatchRollsBackFailedWrite(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"a.go": "alpha\n", "b.go": "beta\n"})
+	// A regular file where the trash directory should be makes trashing fail
+	writeFiles(t, ".", map[string]string{trash.Dir: "not a directory"})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.go":       "package a",
+		"b.go":       "",
+		"sub/new.go": "package sub",
+	}}
+
+	_, err := ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "workspace restored") {
+		t.Fatalf("expected restored failure, got %v", err)
+	}
+
+	assertFile(t, ".", "a.go", "alpha\n")
+	assertFile(t, ".", "b.go", "beta\n")
+	if _, err := os.Stat("sub"); !os.IsNotExist(err) {
+		t.Errorf("created directory should have been removed")
+	}
+}
+
+func TestApplyPatchRollsBackFailedBuildOutsideGit(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"a.go":       "alpha\n",
+		"goctx.json": `{"scripts": {"build": "exit 1"}}`,
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.go": "<<<<<< SEARCH\nalpha\n======\nALPHA\n>>>>>> REPLACE",
+	}}
+
+	_, err := ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "BUILD_FAILURE") {
+		t.Fatalf("expected build failure, got %v", err)
+	}
+	assertFile(t, ".", "a.go", "alpha\n")
+
+	// The note survives cutting the output off the message
+	head, _, _ := strings.Cut(err.Error(), "\n\nOutput:\n")
+	if !strings.HasSuffix(head, "Workspace restored to its pre-patch state.") {
+		t.Errorf("restore note should precede the output, got %q", err)
+	}
+}
+
+func TestApplyPatchStashesOnlyTouchedFilesInGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Chdir(t.TempDir())
+	writeFiles(t, ".", map[string]string{
+		"a.txt":      "alpha\n",
+		"b.txt":      "beta\n",
+		"goctx.json": `{"scripts": [{"name": "test", "run": "! grep -q BROKEN a.txt"}]}`,
+	})
+	runGit(t, "init", "-q")
+	runGit(t, "add", ".")
+	runGit(t, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-qm", "init")
+	// A stash of the user's own, then uncommitted work in and beside the
+	// file the patch touches
+	writeFiles(t, ".", map[string]string{"b.txt": "beta mine\n"})
+	runGit(t, "stash", "push", "-q", "-m", "mine")
+	writeFiles(t, ".", map[string]string{"a.txt": "alpha\nlocal\n", "b.txt": "beta local\n", "c.txt": "untracked\n"})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.txt": "<<<<<< SEARCH\nalpha\n======\nBROKEN\n>>>>>> REPLACE",
+		"d.txt": "new\n",
+	}}
+	report, err := ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "TEST_FAILURE") {
+		t.Fatalf("expected test failure, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "alpha\nlocal\n")
+	assertFile(t, ".", "b.txt", "beta local\n")
+	assertFile(t, ".", "c.txt", "untracked\n")
+	if _, err := os.Stat("d.txt"); !os.IsNotExist(err) {
+		t.Errorf("d.txt should be gone, stat: %v", err)
+	}
+	if report.Recovery == nil {
+		t.Fatalf("expected the failed patch to be stashed: %v", err)
+	}
+	if files := runGit(t, "stash", "show", "--name-only", "--include-untracked", report.Recovery.Commit); files != "a.txt\nd.txt\n" {
+		t.Errorf("stash should hold only the touched files, got %q", files)
+	}
+
+	// Another stash on top must not confuse recovery
+	writeFiles(t, ".", map[string]string{"b.txt": "beta later\n"})
+	runGit(t, "stash", "push", "-q", "-m", "later")
+	if err := stash.Recover(".", report.Recovery); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	assertFile(t, ".", "a.txt", "BROKEN\nlocal\n")
+	assertFile(t, ".", "d.txt", "new\n")
+	assertFile(t, ".", "b.txt", "beta\n")
+	if list := runGit(t, "stash", "list", "--format=%s"); list != "On master: later\nOn master: mine\n" && list != "On main: later\nOn main: mine\n" {
+		t.Errorf("user stashes must be left alone, got %q", list)
+	}
+	if err := stash.Recover(".", report.Recovery); err == nil {
+		t.Error("recovering a dropped stash should fail")
+	}
+}
+
+func TestApplyPatchRollbackIsExactInGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Chdir(t.TempDir())
+	writeFiles(t, ".", map[string]string{
+		"a.txt":      "alpha\n",
+		"goctx.json": `{"scripts": {"build": "exit 1"}}`,
+	})
+	runGit(t, "init", "-q")
+	runGit(t, "config", "core.autocrlf", "true")
+	runGit(t, "add", ".")
+	runGit(t, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-qm", "init")
+
+	// A checkout from git would convert the line endings and reset the mode
+	writeFiles(t, ".", map[string]string{"a.txt": "alpha\r\nlocal\n"})
+	if err := os.Chmod("a.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"a.txt": "<<<<<< SEARCH\nlocal\n======\nchanged\n>>>>>> REPLACE",
+	}}
+	if _, err := ApplyPatch(".", input, nil); err == nil || !strings.Contains(err.Error(), "restored") {
+		t.Fatalf("expected a restored build failure, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "alpha\r\nlocal\n")
+	info, err := os.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestApplyPatchRunsPipelineSteps(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"a.txt": "alpha\n",
+		"goctx.json": `{"scripts": [
+			{"name": "lint", "run": "exit 1", "advisory": true},
+			{"name": "docs", "run": "exit 1", "paths": ["*.md"]},
+			{"name": "integration", "run": "test -f a.txt && exit 2"}
+		]}`,
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{"a.txt": "ALPHA\n"}}
+	var log, steps []string
+	opts := Options{OnStep: func(res pipeline.Result) {
+		steps = append(steps, fmt.Sprintf("%s:%v:%v", pipeline.Label(res.Step), res.Skipped, res.Failed()))
+	}}
+	_, err := ApplyPatchWithOptions(".", input, func(phase, desc, line string) { log = append(log, phase+"|"+line) }, opts)
+	if err == nil || !strings.Contains(err.Error(), "INTEGRATION_FAILURE") {
+		t.Fatalf("expected the required step to fail the patch, got %v", err)
+	}
+	joined := strings.Join(log, "\n")
+	for _, want := range []string{"Advisory step lint failed", "Skipped docs", "Integration|"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("log is missing %q:\n%s", want, joined)
+		}
+	}
+	if got := strings.Join(steps, ","); got != "lint:false:true,docs:true:false,integration:false:true" {
+		t.Errorf("OnStep saw %s", got)
+	}
+	assertFile(t, ".", "a.txt", "alpha\n")
+}
+
+func TestApplyPatchReportsTimeoutsAndCancellation(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"a.txt":      "alpha\n",
+		"goctx.json": `{"scripts": [{"name": "test", "run": "sleep 30", "timeout": "100ms"}]}`,
+	})
+	input := model.ProjectOutput{Files: map[string]string{"a.txt": "ALPHA\n"}}
+
+	_, err := ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "TEST_TIMEOUT") || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "alpha\n")
+
+	writeFiles(t, ".", map[string]string{"goctx.json": `{"scripts": [{"name": "test", "run": "sleep 30"}]}`})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err = ApplyPatchContext(ctx, ".", input, nil, Options{})
+	if err == nil || !strings.Contains(err.Error(), "VERIFICATION_CANCELLED") {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "alpha\n")
+}
+
+func TestApplyPatchReportsGoTestResults(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"a.txt": "alpha\n",
+		"events.json": `{"Action":"pass","Package":"m/a","Test":"TestOK","Elapsed":0.1}
+{"Action":"output","Package":"m/a","Test":"TestBad","Output":"    a_test.go:9: boom\n"}
+{"Action":"fail","Package":"m/a","Test":"TestBad","Elapsed":0.2}
+{"Action":"output","Package":"m/a","Output":"some noise\n"}
+{"Action":"fail","Package":"m/a","Elapsed":0.3}
+`,
+		"goctx.json": `{"scripts": [{"name": "test", "run": "cat events.json; exit 1"}]}`,
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{"a.txt": "ALPHA\n"}}
+	report, err := ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "--- FAIL: m/a.TestBad (0.20s)\n    a_test.go:9: boom") {
+		t.Fatalf("expected per-test failure output, got %v", err)
+	}
+	if strings.Contains(err.Error(), "some noise") {
+		t.Errorf("raw output should be replaced by the failing tests: %v", err)
+	}
+	if report.Tests == nil || !strings.Contains(report.String(), "Tests: 1 failed, 1 passed, 0 skipped") {
+		t.Errorf("report should summarize tests:\n%s", report)
+	}
+}
+
+func TestApplyPatchBaselineIgnoresPreExistingFailures(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"a.txt": "alpha\n",
+		"goctx.json": `{"baseline": true, "scripts": [
+			{"name": "build", "run": "echo './gen.go:3:1: undefined: Generated'; exit 1"},
+			{"name": "test", "run": "! grep -q BROKEN a.txt"}
+		]}`,
+	})
+
+	// build already fails before the patch, so it cannot be blamed on it
+	input := model.ProjectOutput{Files: map[string]string{"a.txt": "ALPHA\n"}}
+	report, err := ApplyPatch(".", input, nil)
+	if err != nil {
+		t.Fatalf("pre-existing failure should not reject the patch: %v", err)
+	}
+	assertFile(t, ".", "a.txt", "ALPHA\n")
+	if len(report.Baseline) != 2 || !report.Baseline[0].BaselineFailed || report.Baseline[0].Regressed() {
+		t.Errorf("report should compare against the baseline: %+v", report.Baseline)
+	}
+
+	// A failure that says nothing about itself is not excused
+	writeFiles(t, ".", map[string]string{"goctx.json": `{"baseline": true, "scripts": [
+		{"name": "build", "run": "test -f missing.txt"}
+	]}`})
+	input = model.ProjectOutput{Files: map[string]string{"a.txt": "alpha\n"}}
+	if _, err := ApplyPatch(".", input, nil); err == nil || !strings.Contains(err.Error(), "BUILD_FAILURE") {
+		t.Fatalf("a failure without signatures should reject the patch, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "ALPHA\n")
+	writeFiles(t, ".", map[string]string{"goctx.json": `{"baseline": true, "scripts": [
+		{"name": "build", "run": "echo './gen.go:3:1: undefined: Generated'; exit 1"},
+		{"name": "test", "run": "! grep -q BROKEN a.txt"}
+	]}`})
+
+	// test passed before, so breaking it is a regression
+	input = model.ProjectOutput{Files: map[string]string{"a.txt": "BROKEN\n"}}
+	report, err = ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), "TEST_FAILURE") {
+		t.Fatalf("new failure should reject the patch, got %v", err)
+	}
+	assertFile(t, ".", "a.txt", "ALPHA\n")
+	if !strings.Contains(report.String(), "Baseline test: newly failing") {
+		t.Errorf("report should show the regression:\n%s", report)
+	}
+}
+
+func assertMode(t *testing.T, root, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(root, path))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	if got := info.Mode().Perm(); got != want {
+		t.Errorf("%s mode = %04o, want %04o", path, got, want)
+	}
+}
+
+func TestApplyPatchPreservesMode(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"run.sh": "#!/bin/sh\necho hi\n"})
+	if err := os.Chmod("run.sh", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"run.sh": "<<<<<< SEARCH\necho hi\n======\necho hello\n>>>>>> REPLACE",
+	}}
+	report, err := ApplyPatch(".", input, nil)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if report.Files[0].Mode != "" {
+		t.Errorf("unexpected mode change %q", report.Files[0].Mode)
+	}
+	assertFile(t, ".", "run.sh", "#!/bin/sh\necho hello\n")
+	assertMode(t, ".", "run.sh", 0755)
+}
+
+func TestApplyPatchModeDirective(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"tool.sh": "#!/bin/sh\n"})
+
+	input := model.ProjectOutput{
+		Files: map[string]string{"new.sh": "#!/bin/sh\necho new", "tool.sh": ""},
+		Modes: map[string]os.FileMode{"new.sh": 0755, "tool.sh": 0700},
+	}
+	report, err := ApplyPatch(".", input, nil)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	modes := map[string]string{}
+	for _, f := range report.Files {
+		modes[f.Path] = f.Mode
+	}
+	if modes["new.sh"] != "0755" || modes["tool.sh"] != "0644 -> 0700" {
+		t.Errorf("unexpected mode report %v", modes)
+	}
+	if !strings.HasPrefix(report.Files[1].Diff, "old mode 100644\nnew mode 100700\n") {
+		t.Errorf("diff should lead with the mode change:\n%s", report.Files[1].Diff)
+	}
+
+	// An empty body with a mode directive keeps the file
+	assertFile(t, ".", "tool.sh", "#!/bin/sh\n")
+	assertMode(t, ".", "tool.sh", 0700)
+	assertMode(t, ".", "new.sh", 0755)
+}
+
+func TestApplyPatchModeDirectiveRejections(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"tool.sh": "#!/bin/sh\n"})
+
+	input := model.ProjectOutput{
+		Files: map[string]string{"missing.sh": "", "tool.sh": ""},
+		Modes: map[string]os.FileMode{"missing.sh": 0755, "tool.sh": 0},
+	}
+	report := mustCheck(t, root, input)
+	if f := report.Files[0]; !strings.Contains(f.Error, "mode change for missing file") {
+		t.Errorf("a mode-only directive must not create a file: %+v", f)
+	}
+	// (mode 000) is a request, not the absence of one
+	if f := report.Files[1]; !strings.Contains(f.Error, "mode 0000 would make the file unreadable") {
+		t.Errorf("mode 000 should be rejected explicitly: %+v", f)
+	}
+	if _, err := ApplyPatch(root, input, nil); err == nil {
+		t.Fatal("expected the patch to be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(root, "missing.sh")); !os.IsNotExist(err) {
+		t.Errorf("missing.sh should not exist, stat: %v", err)
+	}
+	assertMode(t, root, "tool.sh", 0644)
+}
+
+func TestApplyPatchWritesThroughSymlinks(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"real.txt":   "alpha\n",
+		"goctx.json": `{"scripts": {"build": "grep -q ALPHA real.txt"}}`,
+	})
+	if err := os.Symlink("real.txt", filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"link.txt": "<<<<<< SEARCH\nalpha\n======\nALPHA\n>>>>>> REPLACE",
+	}}
+	if _, err := ApplyPatch(root, input, nil); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	assertFile(t, root, "real.txt", "ALPHA\n")
+	if info, err := os.Lstat(filepath.Join(root, "link.txt")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link.txt should still be a symlink: %v", err)
+	}
+}
+
+func TestApplyPatchRename(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"internal/foo/a.go": "package foo\n\nfunc A() {}\n",
+		"notes.txt":         "keep me\n",
+	})
+
+	input := model.ProjectOutput{
+		Files: map[string]string{
+			"internal/bar/a.go": "<<<<<< SEARCH\npackage foo\n======\npackage bar\n>>>>>> REPLACE",
+			"docs/notes.txt":    "",
+		},
+		Renames: map[string]string{
+			"internal/bar/a.go": "internal/foo/a.go",
+			"docs/notes.txt":    "notes.txt",
+		},
+	}
+	report, err := ApplyPatch(".", input, nil)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	for _, f := range report.Files {
+		if f.Action != "rename" {
+			t.Errorf("%s: action = %q, want rename", f.Path, f.Action)
+		}
+	}
+	if !strings.Contains(report.Files[1].Diff, "rename from internal/foo/a.go\nrename to internal/bar/a.go\n--- a/internal/foo/a.go\n+++ b/internal/bar/a.go\n") {
+		t.Errorf("unexpected diff:\n%s", report.Files[1].Diff)
+	}
+
+	assertFile(t, ".", "internal/bar/a.go", "package bar\n\nfunc A() {}\n")
+	assertFile(t, ".", "docs/notes.txt", "keep me\n")
+	for _, gone := range []string{"internal/foo/a.go", "notes.txt"} {
+		if _, err := os.Stat(gone); !os.IsNotExist(err) {
+			t.Errorf("%s should have been moved away", gone)
+		}
+	}
+}
+
+func TestApplyPatchRenameRollsBack(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"a.go":       "alpha\n",
+		"goctx.json": `{"scripts": {"build": "exit 1"}}`,
+	})
+
+	input := model.ProjectOutput{
+		Files:   map[string]string{"sub/b.go": "<<<<<< SEARCH\nalpha\n======\nbeta\n>>>>>> REPLACE"},
+		Renames: map[string]string{"sub/b.go": "a.go"},
+	}
+	if _, err := ApplyPatch(".", input, nil); err == nil {
+		t.Fatal("expected build failure")
+	}
+	assertFile(t, ".", "a.go", "alpha\n")
+	if _, err := os.Stat("sub"); !os.IsNotExist(err) {
+		t.Error("directory created for the move should be removed")
+	}
+}
+
+func TestApplyPatchRenameOntoExistingFile(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"a.go": "a\n", "b.go": "b\n"})
+
+	input := model.ProjectOutput{
+		Files:   map[string]string{"b.go": ""},
+		Renames: map[string]string{"b.go": "a.go"},
+	}
+	if _, err := ApplyPatch(".", input, nil); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected refusal to overwrite, got %v", err)
+	}
+	assertFile(t, ".", "a.go", "a\n")
+	assertFile(t, ".", "b.go", "b\n")
+}
+
+func TestApplyPatchRenameUsesGitMv(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"a.go": "alpha\n"})
+	for _, args := range [][]string{{"init", "-q"}, {"add", "a.go"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	input := model.ProjectOutput{
+		Files:   map[string]string{"b.go": ""},
+		Renames: map[string]string{"b.go": "a.go"},
+	}
+	if _, err := ApplyPatch(".", input, nil); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	out, err := exec.Command("git", "ls-files").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "b.go" {
+		t.Errorf("index should track the moved file, got %q", got)
+	}
+}
+
+func TestApplyPatchDeleteMarker(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{"old.go": "package old\n"})
+
+	input := model.ProjectOutput{Files: map[string]string{"old.go": patch.DeleteMarker, "gone.go": patch.DeleteMarker}}
+	if got := mustCheck(t, ".", input).Deletions(); len(got) != 1 || got[0] != "old.go" {
+		t.Errorf("Deletions = %v, want [old.go]", got)
+	}
+	report, err := ApplyPatch(".", input, nil)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if _, err := os.Stat("old.go"); !os.IsNotExist(err) {
+		t.Error("old.go should have been trashed")
+	}
+	if f := report.Files[0]; f.Path != "gone.go" || f.Action != "already deleted" || !f.OK() {
+		t.Errorf("deleting a missing file should be a no-op: %+v", f)
+	}
+	entries, _ := trash.List(".")
+	if len(entries) != 1 {
+		t.Errorf("only old.go should be in the trash, got %+v", entries)
+	}
+}
+
+func TestApplyPatchRequireDeleteMarker(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"keep.go":    "package keep\n",
+		"goctx.json": `{"require_delete_marker": true}`,
+	})
+
+	// A header followed by an empty fence must not trash the file
+	input := model.ProjectOutput{Files: map[string]string{"keep.go": ""}}
+	if len(mustCheck(t, ".", input).Deletions()) != 0 {
+		t.Error("empty content should not count as a deletion")
+	}
+	_, err := ApplyPatch(".", input, nil)
+	if err == nil || !strings.Contains(err.Error(), patch.DeleteMarker) {
+		t.Fatalf("expected an error pointing at the DELETE marker, got %v", err)
+	}
+	assertFile(t, ".", "keep.go", "package keep\n")
+}
+
+func TestApplyPatchRollbackRestoresTrashedFile(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFiles(t, ".", map[string]string{
+		"old.go":     "package old\n",
+		"goctx.json": `{"scripts": {"build": "exit 1"}}`,
+	})
+
+	input := model.ProjectOutput{
+		ShortDescription: "Remove old",
+		Files:            map[string]string{"old.go": patch.DeleteMarker},
+	}
+	if _, err := ApplyPatch(".", input, nil); err == nil {
+		t.Fatal("expected build failure")
+	}
+	assertFile(t, ".", "old.go", "package old\n")
+	if entries, _ := trash.List("."); len(entries) != 0 {
+		t.Errorf("rolled back deletion should leave the trash empty, got %v", entries)
+	}
+}
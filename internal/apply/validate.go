@@ -0,0 +1,108 @@
+package apply
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxSyntaxErrors caps how many Go syntax errors a rejection lists.
+const maxSyntaxErrors = 5
+
+// validateContent checks that content parses as the type its extension
+// implies: Go source, JSON or YAML. Errors name the line and column. Other
+// file types, and testdata where broken files are often deliberate, pass.
+func validateContent(path string, content []byte) error {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "testdata" {
+			return nil
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return validateGo(path, content)
+	case ".json":
+		if isJSONC(path) {
+			return nil
+		}
+		return validateJSON(path, content)
+	case ".yaml", ".yml":
+		return validateYAML(path, content)
+	}
+	return nil
+}
+
+func validateGo(path string, content []byte) error {
+	fset := token.NewFileSet()
+	_, err := parser.ParseFile(fset, path, content, parser.AllErrors|parser.SkipObjectResolution)
+	var list scanner.ErrorList
+	if !errors.As(err, &list) {
+		return err
+	}
+
+	lines := make([]string, 0, maxSyntaxErrors+1)
+	for i, e := range list {
+		if i == maxSyntaxErrors {
+			lines = append(lines, fmt.Sprintf("(and %d more)", len(list)-i))
+			break
+		}
+		lines = append(lines, e.Error())
+	}
+	return fmt.Errorf("syntax error: %s", strings.Join(lines, "; "))
+}
+
+func validateJSON(path string, content []byte) error {
+	var v any
+	err := json.Unmarshal(content, &v)
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		line, col := lineCol(content, int(syntax.Offset))
+		return fmt.Errorf("syntax error: %s:%d:%d: %v", path, line, col, err)
+	}
+	if err != nil {
+		return fmt.Errorf("syntax error: %s: %v", path, err)
+	}
+	return nil
+}
+
+func validateYAML(path string, content []byte) error {
+	// Files may hold several documents separated by ---
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("syntax error: %s: %v", path, err)
+		}
+	}
+}
+
+// isJSONC reports whether a .json file is conventionally JSON with comments,
+// which encoding/json would wrongly reject.
+func isJSONC(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, "tsconfig") || strings.HasPrefix(base, "jsconfig") ||
+		base == "devcontainer.json" || strings.Contains(path, ".vscode/")
+}
+
+// lineCol converts a byte offset into a 1-based line and column.
+func lineCol(content []byte, offset int) (int, int) {
+	offset = min(max(offset, 0), len(content))
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := offset - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,67 @@
+package apply
+
+import (
+	"strings"
+	"testing"
+
+	"goctx/internal/model"
+)
+
+func TestValidateContent(t *testing.T) {
+	tests := []struct {
+		path    string
+		content string
+		wantErr string // substring, "" for valid
+	}{
+		{"main.go", "package main\n\nfunc main() {}\n", ""},
+		{"main.go", "package main\n\nfunc main() {\n\tx := \n}\n", "main.go:5:1"},
+		{"testdata/bad.go", "not go at all", ""},
+		{"cfg.json", `{"a": [1, 2]}`, ""},
+		{"cfg.json", "{\n  \"a\": 1,\n}", "cfg.json:3:"},
+		{"tsconfig.json", "{\n  // comments are fine here\n}", ""},
+		{"ci.yml", "a: 1\n---\nb: [2, 3]\n", ""},
+		{"ci.yaml", "a: 1\nb: [2, 3\n", "ci.yaml"},
+		{"notes.txt", "{{{ anything", ""},
+	}
+
+	for _, tt := range tests {
+		err := validateContent(tt.path, []byte(tt.content))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("validateContent(%q) = %v, want nil", tt.path, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("validateContent(%q) = %v, want error containing %q", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestApplyPatchRejectsSyntaxErrorsBeforeWriting(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":   "package main\n\nfunc main() {\n\tprintln(1)\n}\n",
+		"other.go":  "package main\n",
+		"legacy.go": "package main\n\nfunc broken( {\n",
+	})
+
+	input := model.ProjectOutput{Files: map[string]string{
+		"main.go":  "<<<<<< SEARCH\n\tprintln(1)\n}\n======\n\tprintln(1\n}\n>>>>>> REPLACE",
+		"other.go": "package main\n\nvar x = 1",
+	}}
+	report, err := ApplyPatch(root, input, nil)
+	if err == nil || !strings.Contains(err.Error(), "main.go:4:") {
+		t.Fatalf("expected a located syntax error, got %v", err)
+	}
+	if f := report.Files[0]; f.OK() || f.Diff == "" || f.FileError() == "" {
+		t.Errorf("syntax error should be reported with the diff: %+v", f)
+	}
+	assertFile(t, root, "main.go", "package main\n\nfunc main() {\n\tprintln(1)\n}\n")
+	assertFile(t, root, "other.go", "package main\n")
+
+	// A file that was already broken may still be patched
+	input = model.ProjectOutput{Files: map[string]string{
+		"legacy.go": "<<<<<< SEARCH\nfunc broken( {\n======\nfunc broken2( {\n>>>>>> REPLACE",
+	}}
+	if _, err := ApplyPatch(root, input, nil); err != nil {
+		t.Errorf("pre-existing syntax errors should not block a patch: %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package baseline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"goctx/internal/builder"
+	"goctx/internal/git"
+	"goctx/internal/model"
+	"goctx/internal/pipeline"
+)
+
+// StepResult is how one verification step went on the unpatched tree.
+type StepResult struct {
+	Failed   bool `json:"failed"`
+	Skipped  bool `json:"skipped,omitempty"`
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Failures are the failing tests and compiler errors, see Signatures.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// Baseline records every verification step run against one tree.
+type Baseline struct {
+	Tree  string                `json:"tree"`
+	Steps map[string]StepResult `json:"steps"`
+	// Cached is set when the baseline came from the cache.
+	Cached bool `json:"-"`
+}
+
+// Capture runs steps against the workspace as it is now, before a patch,
+// and caches the outcome by a hash of the tree and the pipeline so an
+// unchanged workspace is only verified once. Every step runs, even after a
+// required one failed.
+func Capture(ctx context.Context, root string, steps model.Scripts, touched []string, hooks pipeline.Hooks) (*Baseline, error) {
+	tree, err := TreeHash(root)
+	if err != nil {
+		return nil, fmt.Errorf("hash workspace: %w", err)
+	}
+	key, err := cacheKey(tree, steps, touched)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := load(key); err == nil {
+		b.Cached = true
+		return b, nil
+	}
+
+	hooks.Accept = func(pipeline.Result) bool { return true }
+	b := &Baseline{Tree: tree, Steps: make(map[string]StepResult)}
+	for _, res := range pipeline.Run(ctx, root, steps, touched, hooks) {
+		if res.Canceled() {
+			return nil, res.Err
+		}
+		b.Steps[pipeline.Label(res.Step)] = StepResult{
+			Failed:   res.Failed(),
+			Skipped:  res.Skipped,
+			TimedOut: res.TimedOut(),
+			Failures: Signatures(res),
+		}
+	}
+	if err := save(key, b); err != nil {
+		return b, fmt.Errorf("cache baseline: %w", err)
+	}
+	return b, nil
+}
+
+// Comparison sets a step's result after the patch against the baseline.
+type Comparison struct {
+	Step           string `json:"step"`
+	BaselineFailed bool   `json:"baseline_failed"`
+	Failed         bool   `json:"failed"`
+	// TimedOut is set when the step timed out before or after the patch.
+	TimedOut bool `json:"timed_out,omitempty"`
+	// New failures appeared with the patch, Fixed ones went away and
+	// PreExisting ones were there before and still are.
+	New         []string `json:"new,omitempty"`
+	Fixed       []string `json:"fixed,omitempty"`
+	PreExisting []string `json:"pre_existing,omitempty"`
+}
+
+// Regressed reports whether the patch made the step worse: it fails now
+// but passed before, or fails with new tests or compiler errors. A failure
+// is only excused when it has signatures and all of them failed before; a
+// timeout, or a failure without signatures, such as a bare exit status,
+// cannot be shown to be the same one and counts as a regression.
+func (c Comparison) Regressed() bool {
+	if !c.Failed {
+		return false
+	}
+	return !c.BaselineFailed || c.TimedOut || len(c.New) > 0 || len(c.PreExisting) == 0
+}
+
+func (c Comparison) String() string {
+	switch {
+	case !c.Failed && !c.BaselineFailed:
+		return fmt.Sprintf("%s: passes, as before", c.Step)
+	case !c.Failed:
+		return fmt.Sprintf("%s: fixed (%d failure(s) gone)", c.Step, len(c.Fixed))
+	case !c.BaselineFailed:
+		return fmt.Sprintf("%s: newly failing", c.Step)
+	case c.TimedOut:
+		return fmt.Sprintf("%s: timed out, failing before too", c.Step)
+	case len(c.New) == 0 && len(c.PreExisting) == 0:
+		return fmt.Sprintf("%s: failing before too, but not with recognizable failures", c.Step)
+	}
+	return fmt.Sprintf("%s: %d new, %d pre-existing, %d fixed failure(s)", c.Step, len(c.New), len(c.PreExisting), len(c.Fixed))
+}
+
+// Compare matches res against the step of the same name in the baseline.
+// ok is false when the baseline did not run that step.
+func (b *Baseline) Compare(res pipeline.Result) (c Comparison, ok bool) {
+	if b == nil {
+		return Comparison{}, false
+	}
+	name := pipeline.Label(res.Step)
+	base, found := b.Steps[name]
+	if !found || base.Skipped || res.Skipped {
+		return Comparison{}, false
+	}
+	c = Comparison{Step: name, BaselineFailed: base.Failed, Failed: res.Failed(), TimedOut: base.TimedOut || res.TimedOut()}
+
+	before := make(map[string]bool)
+	for _, f := range base.Failures {
+		before[f] = true
+	}
+	after := make(map[string]bool)
+	for _, f := range Signatures(res) {
+		after[f] = true
+		if before[f] {
+			c.PreExisting = append(c.PreExisting, f)
+		} else {
+			c.New = append(c.New, f)
+		}
+	}
+	for _, f := range base.Failures {
+		if !after[f] {
+			c.Fixed = append(c.Fixed, f)
+		}
+	}
+	return c, true
+}
+
+// compileError matches "path/file.go:12:5: message" as printed by the Go
+// toolchain and most linters.
+var compileError = regexp.MustCompile(`(?m)^(?:\./)?([^\s:]+\.go):\d+(?::\d+)?: (.+)$`)
+
+// Signatures identifies a step's failures independently of line numbers,
+// which a patch shifts: failing tests by name, compiler errors by file and
+// message.
+func Signatures(res pipeline.Result) []string {
+	if !res.Failed() {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var sigs []string
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			sigs = append(sigs, s)
+		}
+	}
+	for _, t := range res.Tests.Failures() {
+		if t.Test != "" {
+			add("test " + t.Name())
+			continue
+		}
+		// A package that failed to build says why in its compiler errors;
+		// a panic or timeout outside a test only has the package name
+		errs := compileError.FindAllStringSubmatch(t.Output, -1)
+		for _, m := range errs {
+			add(m[1] + ": " + m[2])
+		}
+		if len(errs) == 0 {
+			add("package " + t.Package)
+		}
+	}
+	if res.Tests == nil {
+		for _, m := range compileError.FindAllStringSubmatch(string(res.Output), -1) {
+			add(m[1] + ": " + m[2])
+		}
+	}
+	sort.Strings(sigs)
+	return sigs
+}
+
+// TreeHash fingerprints the workspace contents: in a git repository every
+// tracked and untracked file that is not ignored, elsewhere every file not
+// matched by the ignore patterns.
+func TreeHash(root string) (string, error) {
+	var files []string
+	var err error
+	if repo, openErr := git.Open(root); openErr == nil {
+		files, err = repo.ListFiles()
+	} else {
+		files, err = builder.GetFileList(root)
+	}
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, rel := range files {
+		full := filepath.Join(root, rel)
+		info, err := os.Lstat(full)
+		if err != nil || info.IsDir() {
+			// Deleted but still in the index, or a submodule
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00", filepath.ToSlash(rel), info.Mode())
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, _ := os.Readlink(full)
+			io.WriteString(h, target)
+		} else if f, err := os.Open(full); err == nil {
+			io.Copy(h, f)
+			f.Close()
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheKey covers everything a baseline depends on besides the tree: the
+// steps and, through {{packages}}, the touched files.
+func cacheKey(tree string, steps model.Scripts, touched []string) (string, error) {
+	data, err := json.Marshal(struct {
+		Tree    string
+		Steps   model.Scripts
+		Touched []string
+	}{tree, steps, touched})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func cacheFile(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "goctx", "baseline", key+".json"), nil
+}
+
+func load(key string) (*Baseline, error) {
+	path, err := cacheFile(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func save(key string, b *Baseline) error {
+	path, err := cacheFile(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,125 @@
+package baseline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"goctx/internal/gotest"
+	"goctx/internal/model"
+	"goctx/internal/pipeline"
+)
+
+func TestSignaturesIgnoreLineNumbers(t *testing.T) {
+	res := pipeline.Result{
+		Step:   model.Step{Name: "build"},
+		Err:    errors.New("exit status 1"),
+		Output: []byte("# example.com/m\n./main.go:10:2: undefined: foo\nnote: unrelated\ninternal/a/a.go:3: missing return\n"),
+	}
+	want := []string{"internal/a/a.go: missing return", "main.go: undefined: foo"}
+	if got := Signatures(res); !reflect.DeepEqual(got, want) {
+		t.Errorf("Signatures() = %q, want %q", got, want)
+	}
+
+	res.Tests = &gotest.Report{Results: []gotest.Result{
+		{Package: "m/a", Test: "TestX", Status: gotest.Fail},
+		{Package: "m/b", Status: gotest.Fail, Output: "b/b.go:1:1: syntax error\n"},
+		{Package: "m/c", Status: gotest.Fail, Output: "panic: boom\n"},
+		{Package: "m/a", Test: "TestY", Status: gotest.Pass},
+	}}
+	want = []string{"b/b.go: syntax error", "package m/c", "test m/a.TestX"}
+	if got := Signatures(res); !reflect.DeepEqual(got, want) {
+		t.Errorf("Signatures() with tests = %q, want %q", got, want)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	b := &Baseline{Steps: map[string]StepResult{
+		"test": {Failed: true, Failures: []string{"test m.TestOld", "test m.TestFlaky"}},
+		"vet":  {Failed: false},
+	}}
+	failing := func(name string, tests ...string) pipeline.Result {
+		report := &gotest.Report{}
+		for _, test := range tests {
+			report.Results = append(report.Results, gotest.Result{Package: "m", Test: test, Status: gotest.Fail})
+		}
+		return pipeline.Result{Step: model.Step{Name: name}, Err: errors.New("exit status 1"), Tests: report}
+	}
+
+	c, ok := b.Compare(failing("test", "TestOld"))
+	if !ok || c.Regressed() || len(c.PreExisting) != 1 || len(c.Fixed) != 1 {
+		t.Errorf("same failures minus one should not regress: %+v", c)
+	}
+	c, _ = b.Compare(failing("test", "TestOld", "TestNew"))
+	if !c.Regressed() || !reflect.DeepEqual(c.New, []string{"test m.TestNew"}) {
+		t.Errorf("a new failing test is a regression: %+v", c)
+	}
+	c, _ = b.Compare(failing("vet"))
+	if !c.Regressed() {
+		t.Errorf("a step that passed before regresses when it fails: %+v", c)
+	}
+	c, _ = b.Compare(failing("test"))
+	if !c.Regressed() {
+		t.Errorf("a failure without signatures cannot be excused: %+v", c)
+	}
+	timedOut := failing("test", "TestOld")
+	timedOut.Err = &pipeline.TimeoutError{Timeout: time.Second}
+	c, _ = b.Compare(timedOut)
+	if !c.Regressed() || !c.TimedOut {
+		t.Errorf("a timeout cannot be excused: %+v", c)
+	}
+	b.Steps["build"] = StepResult{Failed: true}
+	c, _ = b.Compare(failing("build", "TestOld"))
+	if !c.Regressed() {
+		t.Errorf("a baseline without signatures excuses nothing: %+v", c)
+	}
+	if _, ok := b.Compare(failing("lint")); ok {
+		t.Error("steps missing from the baseline cannot be compared")
+	}
+	if _, ok := (*Baseline)(nil).Compare(failing("test")); ok {
+		t.Error("a nil baseline compares nothing")
+	}
+}
+
+func TestCaptureCachesByTree(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	root := t.TempDir()
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("one")
+
+	// Each run appends to a log outside the tree, so cache hits are visible
+	runs := filepath.Join(t.TempDir(), "runs")
+	steps := model.Scripts{{Name: "test", Run: "echo run >> " + runs + "; exit 1"}}
+	capture := func() *Baseline {
+		b, err := Capture(context.Background(), root, steps, []string{"a.txt"}, pipeline.Hooks{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	countRuns := func() int {
+		data, _ := os.ReadFile(runs)
+		return len(data) / len("run\n")
+	}
+
+	if b := capture(); b.Cached || !b.Steps["test"].Failed {
+		t.Fatalf("first capture should run and record the failure: %+v", b)
+	}
+	if b := capture(); !b.Cached || !b.Steps["test"].Failed || countRuns() != 1 {
+		t.Errorf("unchanged tree should reuse the cache: %+v, %d runs", b, countRuns())
+	}
+	write("two")
+	if b := capture(); b.Cached || countRuns() != 2 {
+		t.Errorf("changed tree should run again: %+v, %d runs", b, countRuns())
+	}
+}
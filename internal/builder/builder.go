@@ -53,10 +53,27 @@ const AI_PROMPT_HEADER = `
 	 	package main
 	 	func main (){}
 	 	{backticks}
-	- **(Full File Deletion)**: For file deletions, specify the file with an empty content block.
+	- **(File Permissions)**: Append a mode directive to the header when a file must be executable. With an empty content block only the mode changes.
+	 	eg:
+		{backticks}
+	 	"scripts/release.sh" (mode 0755):
+	 	#!/bin/sh
+	 	{backticks}
+	- **(Move / Rename)**: Move a file with an arrow header instead of deleting and recreating it. Hunks may follow to edit the moved file; leave it empty for a pure move.
+	 	eg:
+		{backticks}
+	 	"internal/foo/a.go" -> "internal/bar/a.go":
+	 	<<<<<< SEARCH
+	 	package foo
+	 	======
+	 	package bar
+	 	>>>>>> REPLACE
+	 	{backticks}
+	- **(Full File Deletion)**: For file deletions, specify the file with the DELETE marker as its only content.
 	 	eg:
 		{backticks}
 	 	"internal/pkg/oldfile.go":
+	 	<<<<<< DELETE >>>>>>
 	 	{backticks}
 	- Always ensure you output code blocks for hunks and search and replace or full file replacement are properly formatted in the chat's ui code block for clipboard transfer. 
 	- {backticks} placeholder to denote where code fence back ticks would be placed if they didn't the prompt's formatting.
@@ -101,8 +118,11 @@ func BuildSelectiveContext(root string, description string, whitelist []string,
 
 	// Smart Mode: LSP-like resolution of dependencies
 	if smartMode {
-		cfg, _ := config.Load(root)
-		related := SmartResolve(root, whitelist, cfg.Scripts.Build)
+		cfg, err := config.Load(root)
+		if err != nil {
+			return model.ProjectOutput{}, err
+		}
+		related := SmartResolve(root, whitelist, cfg.Scripts.Command("build"))
 		for _, r := range related {
 			if !filter[r] {
 				filter[r] = true
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"go/parser"
 	"go/token"
+	"goctx/internal/git"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,7 +15,9 @@ import (
 )
 
 // SmartResolve analyzes the selected files, finds related dependencies (LSP-style),
-// and includes files with build errors. It filters stable/stale dependencies.
+// and includes files with build errors. It filters stable/stale dependencies:
+// only recently modified ones are kept, and in a git repository those with
+// uncommitted changes too, while ignored files are left out.
 func SmartResolve(root string, selectedFiles []string, buildCmd string) []string {
 	tabsRoot, _ := filepath.Abs(root)
 	relatedMap := make(map[string]bool)
@@ -86,6 +89,8 @@ func SmartResolve(root string, selectedFiles []string, buildCmd string) []string
 			Dir string
 		}
 
+		changes := workspaceChanges(root)
+
 		args := append([]string{"list", "-json"}, imports...)
 		cmd := exec.Command("go", args...)
 		cmd.Dir = root
@@ -107,8 +112,7 @@ func SmartResolve(root string, selectedFiles []string, buildCmd string) []string
 									continue
 								}
 
-								info, err := e.Info()
-								if err == nil && time.Since(info.ModTime()) < 120*time.Hour {
+								if keepDependency(changes, relPath, e) {
 									relatedMap[relPath] = true
 								}
 							}
@@ -125,3 +129,45 @@ func SmartResolve(root string, selectedFiles []string, buildCmd string) []string
 	}
 	return result
 }
+
+// keepDependency decides whether a dependency file is worth including. A
+// file with uncommitted changes always is, an ignored one never; otherwise
+// it must have been modified in the last five days. Git lists an ignored
+// directory as a whole, so the file's parents are looked up too.
+func keepDependency(changes map[string]git.StatusEntry, rel string, e os.DirEntry) bool {
+	for dir := rel; dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if status, ok := changes[dir]; ok && status.Ignored() {
+			return false
+		}
+	}
+	if _, ok := changes[rel]; ok {
+		return true
+	}
+	info, err := e.Info()
+	return err == nil && time.Since(info.ModTime()) < 120*time.Hour
+}
+
+// workspaceChanges maps the files with uncommitted changes in root's git
+// working tree, untracked and ignored ones included, to their status. Paths
+// are relative to root; deleted files are left out. It returns nil outside
+// git or when the status cannot be read.
+func workspaceChanges(root string) map[string]git.StatusEntry {
+	repo, err := git.Open(root)
+	if err != nil {
+		return nil
+	}
+	entries, err := repo.Status(git.StatusOptions{Ignored: true})
+	if err != nil {
+		return nil
+	}
+	changes := make(map[string]git.StatusEntry)
+	for _, e := range entries {
+		if e.Removed() {
+			continue
+		}
+		if rel, ok := repo.Rel(e.Path); ok {
+			changes[rel] = e
+		}
+	}
+	return changes
+}
@@ -0,0 +1,127 @@
+package builder
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newRepo returns a fresh git repository in a temporary directory.
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	top := t.TempDir()
+	runGit(t, top, "init", "-q")
+	return top
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+// commitAll commits everything in the repository at dir.
+func commitAll(t *testing.T, dir string) {
+	t.Helper()
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-qm", "init")
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkspaceChanges(t *testing.T) {
+	top := newRepo(t)
+	write := func(name, content string) { writeFile(t, top, name, content) }
+	write("app/stable.go", "package app\n")
+	write("app/edited.go", "package app\n")
+	write("app/old.go", "package app\n")
+	write("app/gone.go", "package app\n")
+	write("other/x.go", "package other\n")
+	commitAll(t, top)
+
+	write("app/edited.go", "package app\n\nvar X = 1\n")
+	runGit(t, top, "mv", "app/old.go", "app/new.go")
+	os.Remove(filepath.Join(top, "app", "gone.go"))
+	write("app/fresh.go", "package app\n")
+	write("other/x.go", "package other\n\nvar Y = 1\n")
+
+	// Opened from a subdirectory, paths are relative to it
+	changes := workspaceChanges(filepath.Join(top, "app"))
+	for _, want := range []string{"edited.go", "new.go", "fresh.go"} {
+		if _, ok := changes[want]; !ok {
+			t.Errorf("%s missing from %v", want, changes)
+		}
+	}
+	if len(changes) != 3 {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+	if e := changes["new.go"]; e.Orig != "app/old.go" {
+		t.Errorf("rename lost its origin: %+v", e)
+	}
+
+	if changes := workspaceChanges(t.TempDir()); changes != nil {
+		t.Errorf("outside git: got %v, want nil", changes)
+	}
+}
+
+func TestKeepDependency(t *testing.T) {
+	top := newRepo(t)
+	write := func(name string, age time.Duration) {
+		t.Helper()
+		writeFile(t, top, name, "package x\n")
+		old := time.Now().Add(-age)
+		if err := os.Chtimes(filepath.Join(top, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, top, ".gitignore", "gen/\n")
+	write("app/recent.go", 0)
+	write("app/stale.go", 240*time.Hour)
+	commitAll(t, top)
+
+	keep := func() map[string]bool {
+		changes := workspaceChanges(top)
+		if changes == nil {
+			t.Fatal("workspaceChanges returned nil inside git")
+		}
+		kept := make(map[string]bool)
+		for _, dir := range []string{"app", "gen"} {
+			entries, _ := os.ReadDir(filepath.Join(top, dir))
+			for _, e := range entries {
+				rel := filepath.Join(dir, e.Name())
+				kept[rel] = keepDependency(changes, rel, e)
+			}
+		}
+		return kept
+	}
+
+	// A clean repository falls back to modification times
+	if kept := keep(); !kept["app/recent.go"] || kept["app/stale.go"] {
+		t.Errorf("clean repository: got %v", kept)
+	}
+
+	// Untracked files count as changed however old, ignored ones never do
+	write("app/untracked.go", 240*time.Hour)
+	write("gen/recent.go", 0)
+	kept := keep()
+	if !kept["app/recent.go"] || kept["app/stale.go"] || !kept["app/untracked.go"] || kept["gen/recent.go"] {
+		t.Errorf("with changes: got %v", kept)
+	}
+}
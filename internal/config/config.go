@@ -2,9 +2,12 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"goctx/internal/model"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
 const (
@@ -18,6 +21,69 @@ const (
 	OverlayOpacity100 = 1.00
 )
 
+// DefaultProtected guards the files that configure or verify GoCtx itself,
+// hold secrets or define CI.
+var DefaultProtected = []string{
+	".git",
+	"keys.json",
+	"goctx.json",
+	"ctx.json",
+	".ctxignore",
+	".gitignore",
+	"go.mod",
+	"go.sum",
+	".github",
+	".gitlab-ci.yml",
+}
+
+// DefaultIsolatedExclude lists the dependency caches left out of the copy an
+// isolated patch is verified in. They are large and rebuilt on demand.
+var DefaultIsolatedExclude = []string{
+	"node_modules",
+	".venv",
+	"__pycache__",
+	".cache",
+}
+
+// Protected returns the protected path patterns in effect for cfg: the
+// defaults plus the patterns goctx.json adds. An entry starting with "!"
+// drops a pattern, so "!go.mod" lets patches change go.mod unconfirmed. An
+// explicit empty list disables protection.
+func Protected(cfg model.Config) []string {
+	return mergePatterns(DefaultProtected, cfg.Protected)
+}
+
+// IsolatedExclude returns the path patterns not copied into an isolated
+// verification copy, merged with the defaults the way Protected does.
+func IsolatedExclude(cfg model.Config) []string {
+	return mergePatterns(DefaultIsolatedExclude, cfg.IsolatedExclude)
+}
+
+// mergePatterns adds the configured patterns to defaults and drops those
+// named with a "!" prefix. A non-nil empty list yields no patterns at all.
+func mergePatterns(defaults, configured []string) []string {
+	if configured != nil && len(configured) == 0 {
+		return nil
+	}
+	dropped := make(map[string]bool)
+	for _, pattern := range configured {
+		if name, ok := strings.CutPrefix(pattern, "!"); ok {
+			dropped[name] = true
+		}
+	}
+	var patterns []string
+	for _, pattern := range append(slices.Clone(defaults), configured...) {
+		if !strings.HasPrefix(pattern, "!") && !dropped[pattern] && !slices.Contains(patterns, pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Load reads goctx.json, or ctx.json when there is none. A file that does
+// not decode is an error rather than a partial configuration, which would
+// silently drop the verification steps or protected paths after the bad
+// value.
 func Load(root string) (model.Config, error) {
 	var cfg model.Config
 	// Priority: goctx.json -> ctx.json
@@ -30,9 +96,10 @@ func Load(root string) (model.Config, error) {
 			if err != nil {
 				return cfg, err
 			}
-			if err := json.Unmarshal(data, &cfg); err == nil {
-				return cfg, nil
+			if err := json.Unmarshal(data, &cfg); err != nil {
+				return model.Config{}, fmt.Errorf("%s: %w", file, err)
 			}
+			return cfg, nil
 		}
 	}
 	return cfg, nil
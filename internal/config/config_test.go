@@ -3,7 +3,11 @@ package config
 import (
 	"goctx/internal/model"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestSaveAndLoadConfig(t *testing.T) {
@@ -16,8 +20,8 @@ func TestSaveAndLoadConfig(t *testing.T) {
 	cfg := model.Config{
 		Ignore: []string{"custom_ignore"},
 		Scripts: model.Scripts{
-			Build: "go build .",
-			Test:  "go test ./...",
+			{Name: "build", Run: "go build ."},
+			{Name: "vet", Run: "go vet ./...", Advisory: true, Timeout: model.Duration(time.Minute)},
 		},
 	}
 
@@ -31,11 +35,62 @@ func TestSaveAndLoadConfig(t *testing.T) {
 		t.Fatalf("Load failed: %v", err)
 	}
 
-	if loaded.Scripts.Build != cfg.Scripts.Build {
-		t.Errorf("Expected build script %q, got %q", cfg.Scripts.Build, loaded.Scripts.Build)
+	if !reflect.DeepEqual(loaded.Scripts, cfg.Scripts) {
+		t.Errorf("Expected scripts %+v, got %+v", cfg.Scripts, loaded.Scripts)
 	}
 
 	if len(loaded.Ignore) != 1 || loaded.Ignore[0] != "custom_ignore" {
 		t.Errorf("Ignore list mismatch: %v", loaded.Ignore)
 	}
 }
+
+func TestProtectedDefaults(t *testing.T) {
+	if got := Protected(model.Config{}); len(got) == 0 || got[0] != DefaultProtected[0] {
+		t.Errorf("missing protected list should use the defaults, got %v", got)
+	}
+	if got := Protected(model.Config{Protected: []string{}}); len(got) != 0 {
+		t.Errorf("an explicit empty list disables protection, got %v", got)
+	}
+
+	got := Protected(model.Config{Protected: []string{"deploy/*.yml", "!go.mod", "keys.json"}})
+	if !slices.Contains(got, "deploy/*.yml") || !slices.Contains(got, ".github") {
+		t.Errorf("listed patterns should add to the defaults, got %v", got)
+	}
+	if slices.Contains(got, "go.mod") || slices.Contains(got, "!go.mod") {
+		t.Errorf("!go.mod should drop the default, got %v", got)
+	}
+	if n := len(got); n != len(DefaultProtected) {
+		t.Errorf("got %d patterns, want %d without duplicates: %v", n, len(DefaultProtected), got)
+	}
+}
+
+func TestLoadRejectsMalformedStep(t *testing.T) {
+	tmpDir := t.TempDir()
+	data := `{"scripts": [{"name": "test", "run": "go test ./...", "timeout": 600}], "require_delete_marker": true}`
+	if err := os.WriteFile(filepath.Join(tmpDir, "goctx.json"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := Load(tmpDir); err == nil {
+		t.Errorf("a numeric timeout should fail to load, got %+v", cfg)
+	}
+}
+
+func TestLoadLegacyScripts(t *testing.T) {
+	tmpDir := t.TempDir()
+	data := `{"scripts": {"test": "go test ./...", "build": "go build ."}}`
+	if err := os.WriteFile(filepath.Join(tmpDir, "goctx.json"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(tmpDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := model.Scripts{{Name: "build", Run: "go build ."}, {Name: "test", Run: "go test ./..."}}
+	if !reflect.DeepEqual(cfg.Scripts, want) {
+		t.Errorf("legacy scripts should become build then test steps, got %+v", cfg.Scripts)
+	}
+	if cfg.Scripts.Command("test") != "go test ./..." {
+		t.Errorf("Command(test) = %q", cfg.Scripts.Command("test"))
+	}
+}
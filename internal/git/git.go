@@ -1,78 +1,183 @@
 package git
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
-func isRepo(root string) bool {
-	_, err := os.Stat(filepath.Join(root, ".git"))
+// ErrNotRepo is returned by Open for a directory outside any git working
+// tree.
+var ErrNotRepo = errors.New("not a git repository")
+
+// Error is a git command that failed, with what git printed about it.
+type Error struct {
+	Args   []string
+	Stderr string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := e.Stderr
+	if msg == "" {
+		msg = e.Err.Error()
+	}
+	return fmt.Sprintf("git %s: %s", e.Args[0], msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Repo runs git commands for one directory of a working tree. Paths given
+// to its methods are relative to Dir, like the paths of a patch; paths in
+// Status are relative to Root, as git reports them.
+type Repo struct {
+	// Root is the top of the working tree.
+	Root string
+	// Dir is the directory the repository was opened from, Root or below.
+	Dir string
+	// Prefix is Dir relative to Root, "" at the top.
+	Prefix string
+}
+
+// Open finds the working tree containing dir, which may be a subdirectory
+// or a linked worktree. It returns an error wrapping ErrNotRepo when there
+// is none.
+func Open(dir string) (*Repo, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	out, err := (&Repo{Dir: abs}).run(nil, "rev-parse", "--show-toplevel", "--show-prefix")
+	var gitErr *Error
+	if errors.As(err, &gitErr) && errors.As(gitErr.Err, new(*exec.ExitError)) {
+		// A plain directory, or a bare repository without a working tree
+		return nil, fmt.Errorf("%s: %w", dir, ErrNotRepo)
+	} else if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(out, "\n")
+	r := &Repo{Root: filepath.FromSlash(lines[0]), Dir: abs}
+	if len(lines) > 1 {
+		r.Prefix = filepath.FromSlash(strings.TrimSuffix(lines[1], "/"))
+	}
+	return r, nil
+}
+
+// IsRepo reports whether dir is inside a git working tree.
+func IsRepo(dir string) bool {
+	_, err := Open(dir)
 	return err == nil
 }
 
-// IsDirty checks if the workspace has uncommitted changes
-func IsDirty(root string) bool {
-	if !isRepo(root) {
-		return false
+// IsDirty reports whether the working tree has uncommitted changes,
+// untracked files included.
+func (r *Repo) IsDirty() (bool, error) {
+	out, err := r.run(nil, "status", "--porcelain=v2", "-z")
+	if err != nil {
+		return false, err
 	}
-	cmd := exec.Command("git", "status", "--porcelain")
-	cmd.Dir = root
-	out, _ := cmd.Output()
-	return len(strings.TrimSpace(string(out))) > 0
+	return out != "", nil
 }
 
-// GetStatusFiles returns paths of modified/added files in the workspace
-func GetStatusFiles(root string) ([]string, error) {
-	if !isRepo(root) {
-		return nil, nil
+// Status lists every change in the working tree against HEAD, untracked
+// files one by one.
+func (r *Repo) Status(opts StatusOptions) ([]StatusEntry, error) {
+	args := []string{"status", "--porcelain=v2", "-z", "--untracked-files=all"}
+	if opts.Ignored {
+		args = append(args, "--ignored=matching")
 	}
-	cmd := exec.Command("git", "status", "--porcelain")
-	cmd.Dir = root
-	out, err := cmd.Output()
+	out, err := r.run(nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(out)
+}
+
+// ChangedFiles lists the files that differ from HEAD, untracked ones
+// included, relative to Root. Both sides of a rename are listed.
+func (r *Repo) ChangedFiles() ([]string, error) {
+	entries, err := r.Status(StatusOptions{})
 	if err != nil {
 		return nil, err
 	}
+	var files []string
+	for _, e := range entries {
+		files = append(files, filepath.FromSlash(e.Path))
+		if e.Orig != "" {
+			files = append(files, filepath.FromSlash(e.Orig))
+		}
+	}
+	return files, nil
+}
 
+// Rel converts a path from Status, relative to Root, to one relative to
+// Dir. ok is false for paths outside Dir.
+func (r *Repo) Rel(path string) (rel string, ok bool) {
+	rel, err := filepath.Rel(r.Prefix, filepath.FromSlash(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// ListFiles returns the tracked and untracked, non-ignored files under
+// Dir, relative to it.
+func (r *Repo) ListFiles() ([]string, error) {
+	out, err := r.run(nil, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
 	var files []string
-	lines := strings.Split(string(out), "\n")
-	for _, line := range lines {
-		if len(line) > 3 {
-			// Porcelain format: XY path
-			files = append(files, strings.TrimSpace(line[3:]))
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(out, "\x00") {
+		// Unmerged files are listed once per stage
+		if f != "" && !seen[f] {
+			seen[f] = true
+			files = append(files, filepath.FromSlash(f))
 		}
 	}
 	return files, nil
 }
 
-// StashPush saves changes to a stash
-func StashPush(root, message string) error {
-	cmd := exec.Command("git", "stash", "push", "-m", message)
-	cmd.Dir = root
-	return cmd.Run()
+// IsTracked reports whether path is in the index. Errors count as not
+// tracked.
+func (r *Repo) IsTracked(path string) bool {
+	_, err := r.run(nil, "ls-files", "--error-unmatch", "--", filepath.ToSlash(path))
+	return err == nil
 }
 
-// StashPop restores the top stash entry
-func StashPop(root string) error {
-	cmd := exec.Command("git", "stash", "pop")
-	cmd.Dir = root
-	return cmd.Run()
+// Move renames a tracked file so git records it as a rename.
+func (r *Repo) Move(from, to string) error {
+	_, err := r.run(nil, "mv", "--", filepath.ToSlash(from), filepath.ToSlash(to))
+	return err
 }
 
-// GetLog returns a list of recent commit hashes and messages
-func GetLog(root string, limit int) ([]string, error) {
-	cmd := exec.Command("git", "log", "--oneline", "-n", fmt.Sprintf("%d", limit))
-	cmd.Dir = root
-	out, err := cmd.Output()
+// AddAll stages every change under Dir.
+func (r *Repo) AddAll() error {
+	_, err := r.run(nil, "add", "--all", "--", ".")
+	return err
+}
+
+// Commit records the index as a new commit.
+func (r *Repo) Commit(message string) error {
+	_, err := r.run(nil, "commit", "-m", message)
+	return err
+}
+
+// Log returns up to limit recent commits as "<short hash> <subject>".
+func (r *Repo) Log(limit int) ([]string, error) {
+	out, err := r.run(nil, "log", "--oneline", "-n", strconv.Itoa(limit))
 	if err != nil {
 		return nil, err
 	}
-
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
 	var result []string
-	for _, line := range lines {
+	for _, line := range strings.Split(out, "\n") {
 		if line != "" {
 			result = append(result, line)
 		}
@@ -80,31 +185,223 @@ func GetLog(root string, limit int) ([]string, error) {
 	return result, nil
 }
 
-// Show returns the diff/content for a specific hash
-func Show(root, hash string) (string, error) {
-	cmd := exec.Command("git", "show", "--color=never", hash)
-	cmd.Dir = root
-	out, err := cmd.Output()
-	return string(out), err
+// Show returns the message and diff of a commit.
+func (r *Repo) Show(rev string) (string, error) {
+	return r.run(nil, "show", "--color=never", rev, "--")
+}
+
+// Checkout overwrites every file under Dir with its content at rev.
+func (r *Repo) Checkout(rev string) error {
+	_, err := r.run(nil, "checkout", rev, "--", ".")
+	return err
+}
+
+// AddWorktree checks out HEAD, detached, into a new working tree at dir.
+func (r *Repo) AddWorktree(dir string) error {
+	_, err := r.run(nil, "worktree", "add", "--detach", "--quiet", dir, "HEAD")
+	return err
+}
+
+// RemoveWorktree deletes a working tree created by AddWorktree, along with
+// any changes made in it.
+func (r *Repo) RemoveWorktree(dir string) error {
+	_, err := r.run(nil, "worktree", "remove", "--force", dir)
+	return err
+}
+
+// Stash is an entry of the stash list.
+type Stash struct {
+	Commit  string
+	Ref     string // position, such as "stash@{2}"; it shifts as entries come and go
+	Message string
+}
+
+// Stashes returns the stash list, newest first.
+func (r *Repo) Stashes() ([]Stash, error) {
+	out, err := r.run(nil, "stash", "list", "-z", "--format=%H %gd %gs")
+	if err != nil {
+		return nil, err
+	}
+	var stashes []Stash
+	for _, entry := range strings.Split(out, "\x00") {
+		fields := strings.SplitN(entry, " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		s := Stash{Commit: fields[0], Ref: fields[1]}
+		if len(fields) == 3 {
+			s.Message = fields[2]
+		}
+		stashes = append(stashes, s)
+	}
+	return stashes, nil
+}
+
+// FindStash returns the stash entry with the given commit. Positions shift
+// as entries are added and dropped, so entries are remembered by commit.
+func (r *Repo) FindStash(commit string) (Stash, error) {
+	stashes, err := r.Stashes()
+	if err != nil {
+		return Stash{}, err
+	}
+	for _, s := range stashes {
+		if s.Commit == commit {
+			return s, nil
+		}
+	}
+	return Stash{}, fmt.Errorf("stash %s not found", shortHash(commit))
+}
+
+// StashPush stashes every change in the working tree and returns the
+// commit of the new entry, or "" when there was nothing to stash.
+func (r *Repo) StashPush(message string) (string, error) {
+	before, _ := r.stashTop()
+	if _, err := r.run(nil, "stash", "push", "-m", message); err != nil {
+		return "", err
+	}
+	after, err := r.stashTop()
+	if err != nil || after == before {
+		return "", nil
+	}
+	return after, nil
+}
+
+// StashPaths records paths as they are in the working tree, deleted ones
+// included, in a new stash entry and returns its commit. The working tree
+// and index are left alone, so the entry holds exactly those files and
+// nothing else the user changed.
+func (r *Repo) StashPaths(message string, paths []string) (string, error) {
+	index, err := os.CreateTemp("", "goctx-index-")
+	if err != nil {
+		return "", err
+	}
+	index.Close()
+	defer os.Remove(index.Name())
+	env := append(os.Environ(), "GIT_INDEX_FILE="+index.Name())
+
+	if _, err := r.run(env, "read-tree", "HEAD"); err != nil {
+		return "", err
+	}
+	args := []string{"update-index", "--add", "--remove", "--"}
+	for _, p := range paths {
+		args = append(args, filepath.ToSlash(p))
+	}
+	if _, err := r.run(env, args...); err != nil {
+		return "", err
+	}
+	tree, err := r.run(env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	// A stash entry is a commit of the working tree whose parents are HEAD
+	// and a commit of the index; the index part is HEAD unchanged here.
+	head, err := r.run(nil, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	var ident []string
+	if _, err := r.run(nil, "var", "GIT_COMMITTER_IDENT"); err != nil {
+		// Like git stash, do not refuse to save work for want of a name
+		ident = append(os.Environ(), "GIT_AUTHOR_NAME=goctx", "GIT_AUTHOR_EMAIL=goctx@localhost",
+			"GIT_COMMITTER_NAME=goctx", "GIT_COMMITTER_EMAIL=goctx@localhost")
+	}
+	indexCommit, err := r.run(ident, "commit-tree", head+"^{tree}", "-p", head, "-m", "index on "+message)
+	if err != nil {
+		return "", err
+	}
+	commit, err := r.run(ident, "commit-tree", tree, "-p", head, "-p", indexCommit, "-m", message)
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.run(nil, "stash", "store", "-m", message, commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// StashPop applies and drops the stash entry with the given commit. It
+// fails, touching nothing, when no such entry exists.
+func (r *Repo) StashPop(commit string) error {
+	s, err := r.FindStash(commit)
+	if err != nil {
+		return err
+	}
+	_, err = r.run(nil, "stash", "pop", s.Ref)
+	return err
+}
+
+// StashDrop deletes the stash entry with the given commit.
+func (r *Repo) StashDrop(commit string) error {
+	s, err := r.FindStash(commit)
+	if err != nil {
+		return err
+	}
+	_, err = r.run(nil, "stash", "drop", s.Ref)
+	return err
+}
+
+// RestorePaths writes paths into the working tree as they are in commit,
+// deleting those it does not contain. The index is not changed.
+func (r *Repo) RestorePaths(commit string, paths []string) error {
+	listArgs := []string{"ls-tree", "-r", "-z", "--name-only", commit, "--"}
+	for _, p := range paths {
+		listArgs = append(listArgs, filepath.ToSlash(p))
+	}
+	out, err := r.run(nil, listArgs...)
+	if err != nil {
+		return err
+	}
+	inCommit := make(map[string]bool)
+	for _, p := range strings.Split(out, "\x00") {
+		inCommit[p] = true
+	}
+
+	// git restore rejects a path that is neither in commit nor on disk
+	args := []string{"restore", "--source=" + commit, "--worktree", "--"}
+	n := len(args)
+	for _, p := range paths {
+		p = filepath.ToSlash(p)
+		if _, err := os.Lstat(filepath.Join(r.Dir, p)); err == nil || inCommit[p] {
+			args = append(args, p)
+		}
+	}
+	if len(args) == n {
+		return nil
+	}
+	_, err = r.run(nil, args...)
+	return err
 }
 
-// Checkout restores files from a specific commit
-func Checkout(root, hash string) error {
-	cmd := exec.Command("git", "checkout", hash, "--", ".")
-	cmd.Dir = root
-	return cmd.Run()
+// stashTop returns the commit of the newest stash entry.
+func (r *Repo) stashTop() (string, error) {
+	return r.run(nil, "rev-parse", "--verify", "--quiet", "refs/stash")
 }
 
-// AddAll stages all changes
-func AddAll(root string) error {
-	cmd := exec.Command("git", "add", ".")
-	cmd.Dir = root
-	return cmd.Run()
+// run runs git in Dir, with env as the whole environment unless it is
+// nil, and returns its output without trailing newlines. A failure is an
+// *Error carrying what git printed.
+func (r *Repo) run(env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	cmd.Env = env
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			// git commit says why it did nothing on stdout
+			msg = strings.TrimSpace(string(out))
+		}
+		return "", &Error{Args: args, Stderr: msg, Err: err}
+	}
+	return strings.TrimRight(string(out), "\n"), nil
 }
 
-// Commit creates a new commit
-func Commit(root, message string) error {
-	cmd := exec.Command("git", "commit", "-m", message)
-	cmd.Dir = root
-	return cmd.Run()
+func shortHash(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
 }
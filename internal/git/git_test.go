@@ -0,0 +1,280 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newRepo creates a repository with a.txt and b.txt committed and returns
+// its top directory.
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "a.txt", "alpha\n")
+	writeFile(t, dir, "b.txt", "beta\n")
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-qm", "init")
+	return dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	top := newRepo(t)
+	writeFile(t, top, "sub/dir/c.txt", "gamma\n")
+
+	repo, err := Open(filepath.Join(top, "sub", "dir"))
+	if err != nil {
+		t.Fatalf("open subdirectory: %v", err)
+	}
+	if repo.Root != top || repo.Prefix != filepath.Join("sub", "dir") {
+		t.Errorf("got root %q prefix %q, want %q and sub/dir", repo.Root, repo.Prefix, top)
+	}
+
+	// A linked worktree has a .git file rather than a directory
+	tree := filepath.Join(t.TempDir(), "tree")
+	if err := repo.AddWorktree(tree); err != nil {
+		t.Fatal(err)
+	}
+	defer repo.RemoveWorktree(tree)
+	wt, err := Open(tree)
+	if err != nil {
+		t.Fatalf("open worktree: %v", err)
+	}
+	if resolved, _ := filepath.EvalSymlinks(tree); wt.Root != resolved || wt.Prefix != "" {
+		t.Errorf("worktree opened as %+v", wt)
+	}
+
+	if _, err := Open(t.TempDir()); !errors.Is(err, ErrNotRepo) {
+		t.Errorf("plain directory: got %v, want ErrNotRepo", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	top := newRepo(t)
+	writeFile(t, top, "a.txt", "alpha changed\n")
+	runGit(t, top, "mv", "b.txt", "renamed.txt")
+	writeFile(t, top, "staged.txt", "new\n")
+	runGit(t, top, "add", "staged.txt")
+	writeFile(t, top, "dir/with space.txt", "untracked\n")
+
+	repo, err := Open(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := repo.Status(StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StatusEntry{
+		{Path: "a.txt", Index: Unmodified, Worktree: Modified},
+		{Path: "renamed.txt", Orig: "b.txt", Index: Renamed, Worktree: Unmodified},
+		{Path: "staged.txt", Index: Added, Worktree: Unmodified},
+		{Path: "dir/with space.txt", Index: Untracked, Worktree: Untracked},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("status:\n got %+v\nwant %+v", entries, want)
+	}
+	if dirty, err := repo.IsDirty(); err != nil || !dirty {
+		t.Errorf("IsDirty = %v, %v", dirty, err)
+	}
+
+	writeFile(t, top, ".gitignore", "*.log\nbuild/\n")
+	writeFile(t, top, "debug.log", "noise\n")
+	writeFile(t, top, "build/out/bin", "binary\n")
+	entries, err = repo.Status(StatusOptions{Ignored: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ignored []string
+	for _, e := range entries {
+		if e.Ignored() {
+			ignored = append(ignored, e.Path)
+		}
+	}
+	if want := []string{"build/", "debug.log"}; !reflect.DeepEqual(ignored, want) {
+		t.Errorf("ignored: got %q, want %q", ignored, want)
+	}
+}
+
+func TestStatusEntryLabel(t *testing.T) {
+	tests := []struct {
+		entry                     StatusEntry
+		label                     string
+		staged, unstaged, removed bool
+	}{
+		{StatusEntry{Index: Unmodified, Worktree: Modified}, "Modified", false, true, false},
+		{StatusEntry{Index: Added, Worktree: Unmodified}, "Added", true, false, false},
+		{StatusEntry{Index: Added, Worktree: Modified}, "Added, Modified", true, true, false},
+		{StatusEntry{Index: Modified, Worktree: Modified}, "Modified", true, true, false},
+		{StatusEntry{Index: Renamed, Worktree: Unmodified, Orig: "old"}, "Renamed", true, false, false},
+		{StatusEntry{Index: Unmodified, Worktree: Deleted}, "Deleted", false, true, true},
+		{StatusEntry{Index: Deleted, Worktree: Unmodified}, "Deleted", true, false, true},
+		{StatusEntry{Index: Untracked, Worktree: Untracked}, "Untracked", false, false, false},
+		{StatusEntry{Index: Unmerged, Worktree: Unmerged}, "Conflict", false, false, false},
+	}
+	for _, tt := range tests {
+		e := tt.entry
+		if e.Label() != tt.label || e.Staged() != tt.staged || e.Unstaged() != tt.unstaged || e.Removed() != tt.removed {
+			t.Errorf("%c%c: got %q staged=%v unstaged=%v removed=%v", e.Index, e.Worktree, e.Label(), e.Staged(), e.Unstaged(), e.Removed())
+		}
+	}
+}
+
+func TestRel(t *testing.T) {
+	repo := &Repo{Prefix: "sub"}
+	if rel, ok := repo.Rel("sub/dir/a.go"); !ok || rel != filepath.Join("dir", "a.go") {
+		t.Errorf("got %q, %v", rel, ok)
+	}
+	if _, ok := repo.Rel("other/a.go"); ok {
+		t.Error("a path outside the prefix should not convert")
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	out := "# branch.oid 1234\x00" +
+		"1 .M N... 100644 100644 100644 aaaa aaaa a.txt\x00" +
+		"2 R. N... 100644 100644 100644 bbbb bbbb R100 new name.txt\x00old name.txt\x00" +
+		"u UU N... 100644 100644 100644 100644 cccc dddd eeee c.txt\x00" +
+		"? d.txt\x00" +
+		"! e.log\x00"
+	entries, err := parseStatus(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StatusEntry{
+		{Path: "a.txt", Index: Unmodified, Worktree: Modified},
+		{Path: "new name.txt", Orig: "old name.txt", Index: Renamed, Worktree: Unmodified},
+		{Path: "c.txt", Index: Unmerged, Worktree: Unmerged},
+		{Path: "d.txt", Index: Untracked, Worktree: Untracked},
+		{Path: "e.log", Index: Ignored, Worktree: Ignored},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %+v\nwant %+v", entries, want)
+	}
+
+	if _, err := parseStatus("1 .M broken\x00"); err == nil {
+		t.Error("expected an error for a truncated entry")
+	}
+}
+
+func TestErrorCarriesStderr(t *testing.T) {
+	repo, err := Open(newRepo(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.Show("no-such-revision")
+	var gitErr *Error
+	if !errors.As(err, &gitErr) {
+		t.Fatalf("got %v, want *Error", err)
+	}
+	if !strings.Contains(gitErr.Stderr, "no-such-revision") || !strings.HasPrefix(err.Error(), "git show: ") {
+		t.Errorf("error does not explain itself: %v", err)
+	}
+}
+
+func TestStashByCommit(t *testing.T) {
+	top := newRepo(t)
+	repo, err := Open(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, top, "a.txt", "first\n")
+	first, err := repo.StashPush("first")
+	if err != nil || first == "" {
+		t.Fatalf("stash push: %q, %v", first, err)
+	}
+	writeFile(t, top, "b.txt", "second\n")
+	second, err := repo.StashPush("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if none, err := repo.StashPush("nothing"); err != nil || none != "" {
+		t.Errorf("clean tree: got %q, %v, want no stash", none, err)
+	}
+
+	// The older entry is popped although it is no longer on top
+	if err := repo.StashPop(first); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(top, "a.txt")); string(data) != "first\n" {
+		t.Errorf("a.txt = %q", data)
+	}
+	stashes, err := repo.Stashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stashes) != 1 || stashes[0].Commit != second || stashes[0].Ref != "stash@{0}" || !strings.HasSuffix(stashes[0].Message, "second") {
+		t.Errorf("stash list: %+v", stashes)
+	}
+	if err := repo.StashPop(first); err == nil {
+		t.Error("popping a stash that is gone should fail")
+	}
+}
+
+func TestStashPathsLeavesTreeAlone(t *testing.T) {
+	top := newRepo(t)
+	repo, err := Open(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, top, "a.txt", "patched\n")
+	writeFile(t, top, "b.txt", "user work\n")
+	writeFile(t, top, "new.txt", "created\n")
+
+	commit, err := repo.StashPaths("failed patch", []string{"a.txt", "new.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := runGit(t, top, "diff", "--name-only", commit+"^1", commit); files != "a.txt\nnew.txt\n" {
+		t.Errorf("stash holds %q", files)
+	}
+	if st := runGit(t, top, "status", "--porcelain"); st != " M a.txt\n M b.txt\n?? new.txt\n" {
+		t.Errorf("working tree changed:\n%s", st)
+	}
+
+	writeFile(t, top, "a.txt", "alpha\n")
+	os.Remove(filepath.Join(top, "new.txt"))
+	if err := repo.RestorePaths(commit, []string{"a.txt", "new.txt", "gone.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(top, "new.txt")); string(data) != "created\n" {
+		t.Errorf("new.txt = %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(top, "a.txt")); string(data) != "patched\n" {
+		t.Errorf("a.txt = %q", data)
+	}
+}
@@ -0,0 +1,157 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// State is the status of a file on one side of `git status`: the index
+// against HEAD, or the working tree against the index. The values are the
+// letters of the porcelain format.
+type State byte
+
+const (
+	Unmodified  State = '.'
+	Modified    State = 'M'
+	TypeChanged State = 'T'
+	Added       State = 'A'
+	Deleted     State = 'D'
+	Renamed     State = 'R'
+	Copied      State = 'C'
+	Unmerged    State = 'U'
+	Untracked   State = '?'
+	Ignored     State = '!'
+)
+
+var stateNames = map[State]string{
+	Unmodified:  "Unmodified",
+	Modified:    "Modified",
+	TypeChanged: "Type Changed",
+	Added:       "Added",
+	Deleted:     "Deleted",
+	Renamed:     "Renamed",
+	Copied:      "Copied",
+	Unmerged:    "Conflict",
+	Untracked:   "Untracked",
+	Ignored:     "Ignored",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return string(s)
+}
+
+// StatusOptions selects what Status lists besides changed files.
+type StatusOptions struct {
+	// Ignored lists ignored files too. A directory that is ignored as a
+	// whole is one entry whose path ends in a slash.
+	Ignored bool
+}
+
+// StatusEntry is one changed file from `git status --porcelain=v2`.
+type StatusEntry struct {
+	// Path is relative to the top of the working tree, slash-separated.
+	Path string
+	// Orig is the path a renamed or copied file came from.
+	Orig string
+	// Index is the staged change, Worktree the unstaged one. Untracked
+	// and ignored files have the same state on both sides.
+	Index    State
+	Worktree State
+}
+
+// Untracked reports whether git does not know the file yet.
+func (e StatusEntry) Untracked() bool {
+	return e.Index == Untracked
+}
+
+// Ignored reports whether the file matches an ignore pattern.
+func (e StatusEntry) Ignored() bool {
+	return e.Index == Ignored
+}
+
+// Conflicted reports whether the file has unresolved merge conflicts.
+func (e StatusEntry) Conflicted() bool {
+	return e.Index == Unmerged
+}
+
+// Staged reports whether the index holds a change to the file.
+func (e StatusEntry) Staged() bool {
+	return e.tracked() && e.Index != Unmodified
+}
+
+// Unstaged reports whether the working tree differs from the index.
+func (e StatusEntry) Unstaged() bool {
+	return e.tracked() && e.Worktree != Unmodified
+}
+
+// Removed reports whether the file is gone from the working tree.
+func (e StatusEntry) Removed() bool {
+	return e.Worktree == Deleted || e.Index == Deleted && e.Worktree == Unmodified
+}
+
+func (e StatusEntry) tracked() bool {
+	return !e.Untracked() && !e.Ignored() && !e.Conflicted()
+}
+
+// Label describes the change for display: "Untracked", "Conflict",
+// "Renamed", "Modified", or both sides when they differ, such as
+// "Added, Modified" for a file staged and then edited again.
+func (e StatusEntry) Label() string {
+	switch {
+	case !e.tracked() || !e.Unstaged():
+		return e.Index.String()
+	case !e.Staged():
+		return e.Worktree.String()
+	case e.Index == e.Worktree:
+		return e.Index.String()
+	}
+	return e.Index.String() + ", " + e.Worktree.String()
+}
+
+// parseStatus reads the NUL-separated output of
+// `git status --porcelain=v2 -z`. Paths in this format are never quoted.
+func parseStatus(out string) ([]StatusEntry, error) {
+	records := strings.Split(out, "\x00")
+	var entries []StatusEntry
+	for i := 0; i < len(records); i++ {
+		rec := records[i]
+		if rec == "" {
+			continue
+		}
+		switch rec[0] {
+		case '1':
+			// 1 XY sub mH mI mW hH hI path
+			fields := strings.SplitN(rec, " ", 9)
+			if len(fields) != 9 || len(fields[1]) != 2 {
+				return nil, fmt.Errorf("git status: malformed entry %q", rec)
+			}
+			entries = append(entries, StatusEntry{Path: fields[8], Index: State(fields[1][0]), Worktree: State(fields[1][1])})
+		case '2':
+			// 2 XY sub mH mI mW hH hI Xscore path, then the original path
+			fields := strings.SplitN(rec, " ", 10)
+			if len(fields) != 10 || len(fields[1]) != 2 || i+1 >= len(records) {
+				return nil, fmt.Errorf("git status: malformed entry %q", rec)
+			}
+			i++
+			entries = append(entries, StatusEntry{Path: fields[9], Orig: records[i], Index: State(fields[1][0]), Worktree: State(fields[1][1])})
+		case 'u':
+			// u XY sub m1 m2 m3 mW h1 h2 h3 path
+			fields := strings.SplitN(rec, " ", 11)
+			if len(fields) != 11 {
+				return nil, fmt.Errorf("git status: malformed entry %q", rec)
+			}
+			entries = append(entries, StatusEntry{Path: fields[10], Index: Unmerged, Worktree: Unmerged})
+		case '?', '!':
+			state := State(rec[0])
+			entries = append(entries, StatusEntry{Path: rec[min(2, len(rec)):], Index: state, Worktree: state})
+		case '#':
+			// Branch headers
+		default:
+			return nil, fmt.Errorf("git status: unknown entry %q", rec)
+		}
+	}
+	return entries, nil
+}
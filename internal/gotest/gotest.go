@@ -0,0 +1,184 @@
+package gotest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Status is the outcome of a test or package.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	Skip Status = "skip"
+)
+
+// Event is one line of `go test -json` output, as described by
+// `go doc test2json`.
+type Event struct {
+	Time       time.Time `json:",omitempty"`
+	Action     string
+	Package    string  `json:",omitempty"`
+	ImportPath string  `json:",omitempty"` // build-output and build-fail events
+	Test       string  `json:",omitempty"`
+	Elapsed    float64 `json:",omitempty"`
+	Output     string  `json:",omitempty"`
+}
+
+// ParseEvent decodes a single line of `go test -json` output. It returns
+// false for lines that are not test events, such as plain build errors.
+func ParseEvent(line string) (Event, bool) {
+	var ev Event
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil || ev.Action == "" {
+		return Event{}, false
+	}
+	return ev, true
+}
+
+// Result is the outcome of one test, or of a whole package when Test is
+// empty (a package that failed to build, or failed outside any test).
+type Result struct {
+	Package string        `json:"package"`
+	Test    string        `json:"test,omitempty"`
+	Status  Status        `json:"status"`
+	Elapsed time.Duration `json:"elapsed"`
+	// Output is kept for failures only.
+	Output string `json:"output,omitempty"`
+}
+
+// Name is the test's qualified name, e.g. "goctx/internal/patch.TestApply".
+func (r Result) Name() string {
+	if r.Test == "" {
+		return r.Package
+	}
+	return r.Package + "." + r.Test
+}
+
+// Report collects the results of one `go test -json` run.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Parse reads a `go test -json` event stream. Lines that are not events,
+// like output of a command run before go test, are ignored. ok is false
+// when the data holds no test events at all.
+func Parse(data []byte) (report *Report, ok bool) {
+	output := make(map[resultKey]*strings.Builder)
+	results := make(map[resultKey]*Result)
+	var order []resultKey
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		ev, isEvent := ParseEvent(scanner.Text())
+		if !isEvent {
+			continue
+		}
+		ok = true
+
+		k := resultKey{ev.Package, ev.Test}
+		switch ev.Action {
+		case "build-output", "build-fail":
+			// Build failures are keyed by the package that failed to build
+			k = resultKey{ev.ImportPath, ""}
+			if k.pkg == "" {
+				continue
+			}
+		}
+		if output[k] == nil {
+			output[k] = &strings.Builder{}
+		}
+
+		switch ev.Action {
+		case "output", "build-output":
+			output[k].WriteString(ev.Output)
+		case "pass", "fail", "skip", "build-fail":
+			status := Status(ev.Action)
+			if ev.Action == "build-fail" {
+				status = Fail
+			}
+			if results[k] == nil {
+				order = append(order, k)
+			}
+			results[k] = &Result{
+				Package: k.pkg,
+				Test:    k.test,
+				Status:  status,
+				Elapsed: time.Duration(ev.Elapsed * float64(time.Second)),
+			}
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	report = &Report{}
+	for _, k := range order {
+		res := *results[k]
+		if k.test == "" && (res.Status != Fail || failedTests(results, k.pkg)) {
+			// Package lines only repeat what their tests say. A failed
+			// package without a failed test is a build error, a panic in
+			// TestMain or a timeout, which only its own output explains.
+			continue
+		}
+		if res.Status == Fail {
+			res.Output = output[k].String()
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, true
+}
+
+// resultKey identifies a test, or a package when test is empty.
+type resultKey struct{ pkg, test string }
+
+// failedTests reports whether any test of pkg failed.
+func failedTests(results map[resultKey]*Result, pkg string) bool {
+	for _, r := range results {
+		if r.Package == pkg && r.Test != "" && r.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of results with the given status.
+func (r *Report) Count(status Status) int {
+	if r == nil {
+		return 0
+	}
+	n := 0
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failures returns the failed tests and packages, sorted by name.
+func (r *Report) Failures() []Result {
+	if r == nil {
+		return nil
+	}
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Status == Fail {
+			failed = append(failed, res)
+		}
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Name() < failed[j].Name() })
+	return failed
+}
+
+// Summary counts the results, e.g. "2 failed, 40 passed, 1 skipped".
+func (r *Report) Summary() string {
+	return fmt.Sprintf("%d failed, %d passed, %d skipped", r.Count(Fail), r.Count(Pass), r.Count(Skip))
+}
@@ -0,0 +1,62 @@
+package gotest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const stream = `{"Action":"start","Package":"example.com/m/a"}
+{"Action":"run","Package":"example.com/m/a","Test":"TestOK"}
+{"Action":"output","Package":"example.com/m/a","Test":"TestOK","Output":"=== RUN   TestOK\n"}
+{"Action":"pass","Package":"example.com/m/a","Test":"TestOK","Elapsed":0.25}
+{"Action":"run","Package":"example.com/m/a","Test":"TestBad"}
+{"Action":"output","Package":"example.com/m/a","Test":"TestBad","Output":"=== RUN   TestBad\n"}
+{"Action":"output","Package":"example.com/m/a","Test":"TestBad","Output":"    a_test.go:9: got 1, want 2\n"}
+{"Action":"fail","Package":"example.com/m/a","Test":"TestBad","Elapsed":0.01}
+{"Action":"skip","Package":"example.com/m/a","Test":"TestLater","Elapsed":0}
+{"Action":"output","Package":"example.com/m/a","Output":"FAIL\n"}
+{"Action":"fail","Package":"example.com/m/a","Elapsed":0.3}
+{"ImportPath":"example.com/m/b","Action":"build-output","Output":"b/b.go:3:1: syntax error\n"}
+{"ImportPath":"example.com/m/b","Action":"build-fail"}
+{"Action":"fail","Package":"example.com/m/b","Elapsed":0}
+{"Action":"skip","Package":"example.com/m/c","Elapsed":0}
+not json: some unrelated line
+`
+
+func TestParse(t *testing.T) {
+	report, ok := Parse([]byte(stream))
+	if !ok {
+		t.Fatal("stream not recognised")
+	}
+	if got := report.Summary(); got != "2 failed, 1 passed, 1 skipped" {
+		t.Errorf("Summary() = %q", got)
+	}
+
+	failures := report.Failures()
+	if len(failures) != 2 {
+		t.Fatalf("Failures() = %+v", failures)
+	}
+	bad := failures[0]
+	if bad.Name() != "example.com/m/a.TestBad" || bad.Elapsed != 10*time.Millisecond || !strings.Contains(bad.Output, "got 1, want 2") {
+		t.Errorf("unexpected test failure: %+v", bad)
+	}
+	build := failures[1]
+	if build.Name() != "example.com/m/b" || !strings.Contains(build.Output, "syntax error") {
+		t.Errorf("build failure should be reported with its output: %+v", build)
+	}
+	for _, r := range report.Results {
+		if r.Status == Pass && r.Output != "" {
+			t.Errorf("output kept for passing test %s", r.Name())
+		}
+	}
+}
+
+func TestParseIgnoresPlainOutput(t *testing.T) {
+	if _, ok := Parse([]byte("ok  \texample.com/m/a\t0.01s\n")); ok {
+		t.Error("plain go test output should not parse as events")
+	}
+	if _, ok := ParseEvent(`{"not": "an event"}`); ok {
+		t.Error("JSON without an Action is not an event")
+	}
+}
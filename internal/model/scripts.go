@@ -0,0 +1,92 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Step is one named command of the verification pipeline.
+type Step struct {
+	Name string `json:"name"`
+	Run  string `json:"run"`
+	// Advisory steps report a failure without rejecting the patch.
+	Advisory bool `json:"advisory,omitempty"`
+	// Timeout stops the step after a Go duration such as "5m".
+	Timeout Duration `json:"timeout,omitempty"`
+	// Dir is the working directory, relative to the project root.
+	Dir string `json:"dir,omitempty"`
+	// Env adds variables to the step's environment.
+	Env map[string]string `json:"env,omitempty"`
+	// Paths limits the step to patches touching a file that matches one of
+	// these globs. Empty means the step always runs.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// Scripts is the ordered verification pipeline. The older form
+// {"build": "...", "test": "..."} is still accepted and becomes a build
+// step followed by a test step.
+type Scripts []Step
+
+// Command returns the command of the first step called name, or "".
+func (s Scripts) Command(name string) string {
+	for _, step := range s {
+		if step.Name == name {
+			return step.Run
+		}
+	}
+	return ""
+}
+
+func (s *Scripts) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var steps []Step
+		if err := json.Unmarshal(data, &steps); err != nil {
+			return err
+		}
+		*s = steps
+		return nil
+	}
+	if bytes.Equal(data, []byte("null")) {
+		*s = nil
+		return nil
+	}
+
+	var legacy struct {
+		Build string `json:"build"`
+		Test  string `json:"test"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	*s = nil
+	if legacy.Build != "" {
+		*s = append(*s, Step{Name: "build", Run: legacy.Build})
+	}
+	if legacy.Test != "" {
+		*s = append(*s, Step{Name: "test", Run: legacy.Test})
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as a Go duration string, e.g. "90s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5m\": %w", err)
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
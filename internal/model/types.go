@@ -1,14 +1,45 @@
 package model
 
-type Scripts struct {
-	Test  string `json:"test,omitempty"`
-	Build string `json:"build,omitempty"`
+import "os"
+
+// Matching tunes how forgiving the apply engine is when a SEARCH block does
+// not match the target file exactly.
+type Matching struct {
+	// Strict disables similarity-scored matching entirely.
+	Strict bool `json:"strict,omitempty"`
+	// MinScore is the lowest similarity (0-1) a scored match may have.
+	MinScore float64 `json:"min_score,omitempty"`
+	// ConfirmScore is the similarity below which the GUI asks before applying.
+	ConfirmScore float64 `json:"confirm_score,omitempty"`
 }
 
 type Config struct {
 	Ignore     []string `json:"ignore"`
 	Extensions []string `json:"extensions"`
 	Scripts    Scripts  `json:"scripts"`
+	Matching   Matching `json:"matching"`
+	// RequireDeleteMarker makes an empty file body an error instead of a
+	// deletion, so only an explicit DELETE marker trashes a file.
+	RequireDeleteMarker bool `json:"require_delete_marker,omitempty"`
+	// Protected lists glob patterns of files a patch may only change after
+	// explicit confirmation, on top of config.DefaultProtected. A "!" prefix
+	// drops a pattern; an empty list disables protection.
+	Protected []string `json:"protected,omitempty"`
+	// Formatters maps a file extension to the formatter run on patched files:
+	// "gofmt" for the built-in Go formatter, or a shell command in which
+	// {{file}} is replaced by the file's path.
+	Formatters map[string]string `json:"formatters,omitempty"`
+	// Baseline runs the pipeline on the unpatched workspace first, so only
+	// failures the patch introduces reject it.
+	Baseline bool `json:"baseline,omitempty"`
+	// Isolated applies and verifies patches in a temporary git worktree, or
+	// a copy outside git, and only writes them to the workspace once they
+	// pass.
+	Isolated bool `json:"isolated,omitempty"`
+	// IsolatedExclude lists path patterns left out of the isolated copy, on
+	// top of config.DefaultIsolatedExclude. Other files ignored by git are
+	// copied, since builds often need them.
+	IsolatedExclude []string `json:"isolated_exclude,omitempty"`
 }
 
 type ProjectOutput struct {
@@ -18,4 +49,10 @@ type ProjectOutput struct {
 	FileCount        int               `json:"file_count"`
 	TokenCount       int               `json:"token_count"`
 	DirCount         int               `json:"dir_count"`
+
+	// Modes holds permission bits a patch requests for some of its files.
+	Modes map[string]os.FileMode `json:"modes,omitempty"`
+	// Renames maps a file's new path to the path it is moved from. Its entry
+	// in Files holds hunks or content applied after the move.
+	Renames map[string]string `json:"renames,omitempty"`
 }
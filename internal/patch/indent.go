@@ -0,0 +1,102 @@
+package patch
+
+import "strings"
+
+// indentStyle describes how a block of lines is indented: with tabs, or with
+// spaces in steps of width columns.
+type indentStyle struct {
+	tabs  bool
+	width int
+}
+
+// leadingWhitespace returns the indentation prefix of s.
+func leadingWhitespace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// firstIndent returns the indentation of the first non-blank line.
+func firstIndent(lines []string) (string, bool) {
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			return leadingWhitespace(l), true
+		}
+	}
+	return "", false
+}
+
+// detectIndentStyle guesses the indentation style of lines, falling back to
+// the given style when no line is indented.
+func detectIndentStyle(lines []string, fallback indentStyle) indentStyle {
+	smallest := 0
+	for _, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		lead := leadingWhitespace(l)
+		if trimmed == "" || lead == "" {
+			continue
+		}
+		if lead[0] == '\t' {
+			return indentStyle{tabs: true, width: 4}
+		}
+		// Block comment continuations (" * foo") are alignment, not indentation.
+		if strings.HasPrefix(trimmed, "*") {
+			continue
+		}
+		if n := len(lead); smallest == 0 || n < smallest {
+			smallest = n
+		}
+	}
+	if smallest == 0 {
+		return fallback
+	}
+	return indentStyle{width: smallest}
+}
+
+// levels splits an indentation prefix into whole indentation levels and the
+// leftover alignment spaces.
+func (st indentStyle) levels(lead string) (int, int) {
+	tabs := strings.Count(lead, "\t")
+	spaces := strings.Count(lead, " ")
+	return tabs + spaces/st.width, spaces % st.width
+}
+
+func (st indentStyle) render(levels int) string {
+	if st.tabs {
+		return strings.Repeat("\t", levels)
+	}
+	return strings.Repeat(" ", levels*st.width)
+}
+
+// reindent shifts replace by the indentation difference between the SEARCH
+// block and the file region it matched, rewriting it in the file's indent
+// style so a de-indented or space-converted hunk lands where it belongs.
+func reindent(replace string, searchLines, matchedLines, fileLines []string) string {
+	searchBase, ok := firstIndent(searchLines)
+	if !ok {
+		return replace
+	}
+	fileBase, ok := firstIndent(matchedLines)
+	if !ok {
+		return replace
+	}
+
+	replaceLines := strings.Split(replace, "\n")
+	src := detectIndentStyle(append(append([]string{}, searchLines...), replaceLines...), indentStyle{width: 4})
+	dst := detectIndentStyle(fileLines, src)
+	if searchBase == fileBase && src == dst {
+		return replace
+	}
+
+	srcBase, _ := src.levels(searchBase)
+	dstBase, _ := dst.levels(fileBase)
+
+	for i, l := range replaceLines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		lead := leadingWhitespace(l)
+		lvl, align := src.levels(lead)
+		target := max(dstBase+lvl-srcBase, 0)
+		replaceLines[i] = dst.render(target) + strings.Repeat(" ", align) + l[len(lead):]
+	}
+	return strings.Join(replaceLines, "\n")
+}
@@ -1,17 +1,34 @@
 package patch
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"goctx/internal/model"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// DeleteMarker is the body that explicitly asks for a file to be deleted.
+const DeleteMarker = "<<<<<< DELETE >>>>>>"
+
+// IsDeleteMarker reports whether a file body is the explicit deletion marker.
+func IsDeleteMarker(content string) bool {
+	return strings.TrimSpace(content) == DeleteMarker
+}
+
 // ParseNative detects and parses a custom "Native Dialect" for patches.
 // It extracts file blocks defined by "filename": header followed by standard SEARCH/REPLACE hunks.
+// A header may request permission bits, e.g. "script.sh" (mode 0755):, in
+// which case an empty body changes only the mode instead of deleting the file.
+// A header of the form "old.go" -> "new.go": moves a file, applying its body
+// (if any) to the moved file; an empty body here is a pure move.
 func ParseNative(text string) (model.ProjectOutput, bool) {
 	// Regex matches lines starting with a quoted string and a colon, e.g. "path/file.go":
-	re := regexp.MustCompile(`(?m)^"([^"]+)":`)
+	re := regexp.MustCompile(`(?m)^"([^"]+)"(?:[ \t]*->[ \t]*"([^"]+)")?(?:[ \t]*\(mode[ \t]+([0-7]{3,4})\))?:`)
 
 	matches := re.FindAllStringSubmatchIndex(text, -1)
 	if len(matches) == 0 {
@@ -27,6 +44,25 @@ func ParseNative(text string) (model.ProjectOutput, bool) {
 		// Group 1 is the filename (indices 2 and 3)
 		filename := text[match[2]:match[3]]
 
+		// Group 2 is the optional rename target (indices 4 and 5)
+		if match[4] != -1 {
+			if out.Renames == nil {
+				out.Renames = make(map[string]string)
+			}
+			from := filename
+			filename = text[match[4]:match[5]]
+			out.Renames[filename] = from
+		}
+
+		// Group 3 is the optional octal mode (indices 6 and 7)
+		if match[6] != -1 {
+			mode, _ := strconv.ParseUint(text[match[6]:match[7]], 8, 32)
+			if out.Modes == nil {
+				out.Modes = make(map[string]os.FileMode)
+			}
+			out.Modes[filename] = os.FileMode(mode).Perm()
+		}
+
 		// Content starts after the current match (colon)
 		start := match[1]
 		end := len(text)
@@ -69,3 +105,23 @@ func ParseNative(text string) (model.ProjectOutput, bool) {
 
 	return out, true
 }
+
+// Fingerprint identifies a patch by the edits it makes, ignoring its
+// description, so the same patch copied twice can be recognised.
+func Fingerprint(p model.ProjectOutput) string {
+	paths := make([]string, 0, len(p.Files))
+	for path := range p.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		mode := "-"
+		if m, ok := p.Modes[path]; ok {
+			mode = fmt.Sprintf("%o", m)
+		}
+		fmt.Fprintf(h, "%d:%s%d:%s%s;%q;", len(path), path, len(p.Files[path]), p.Files[path], mode, p.Renames[path])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
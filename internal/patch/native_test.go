@@ -1,8 +1,11 @@
 package patch
 
 import (
+	"os"
 	"strings"
 	"testing"
+
+	"goctx/internal/model"
 )
 
 func TestParseNative_SingleFile(t *testing.T) {
@@ -54,3 +57,75 @@ D
 		t.Fatalf("Failed to parse multiple files, got %d", len(output.Files))
 	}
 }
+
+func TestParseNative_ModeDirective(t *testing.T) {
+	input := `
+"script.sh" (mode 0755):
+#!/bin/sh
+echo hi
+
+"plain.txt":
+text
+
+"locked.txt" (mode 000):
+`
+	output, ok := ParseNative(input)
+	if !ok || len(output.Files) != 3 {
+		t.Fatalf("Failed to parse files, got %d", len(output.Files))
+	}
+	if got := output.Modes["script.sh"]; got != 0755 {
+		t.Errorf("Expected mode 0755, got %04o", got)
+	}
+	if _, ok := output.Modes["plain.txt"]; ok {
+		t.Error("Files without a directive should not get a mode")
+	}
+	if got, ok := output.Modes["locked.txt"]; !ok || got != 0 {
+		t.Errorf("Expected an explicit mode 0000, got %04o (present %v)", got, ok)
+	}
+	if Fingerprint(output) == Fingerprint(model.ProjectOutput{Files: output.Files, Modes: map[string]os.FileMode{"script.sh": 0755}}) {
+		t.Error("mode 000 should count in the fingerprint")
+	}
+	if !strings.HasPrefix(output.Files["script.sh"], "#!/bin/sh") {
+		t.Errorf("Directive leaked into content: %q", output.Files["script.sh"])
+	}
+}
+
+func TestParseNative_Rename(t *testing.T) {
+	input := `
+"internal/foo/a.go" -> "internal/bar/a.go":
+<<<<<< SEARCH
+package foo
+======
+package bar
+>>>>>> REPLACE
+
+"old.txt" -> "new.txt":
+`
+	output, ok := ParseNative(input)
+	if !ok || len(output.Files) != 2 {
+		t.Fatalf("Failed to parse renames, got %d files", len(output.Files))
+	}
+	if got := output.Renames["internal/bar/a.go"]; got != "internal/foo/a.go" {
+		t.Errorf("Expected rename from internal/foo/a.go, got %q", got)
+	}
+	if !strings.Contains(output.Files["internal/bar/a.go"], "package bar") {
+		t.Errorf("Hunks should be keyed by the new path: %v", output.Files)
+	}
+	if content, ok := output.Files["new.txt"]; !ok || content != "" || output.Renames["new.txt"] != "old.txt" {
+		t.Errorf("Expected a pure move of old.txt, got %q (%v)", content, output.Renames)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a, _ := ParseNative("\"a.go\":\nA\n\n\"b.go\":\nB\n")
+	b, _ := ParseNative("\"b.go\":\nB\n\n\"a.go\":\nA\n")
+	b.ShortDescription = "Copied again"
+	if Fingerprint(a) != Fingerprint(b) {
+		t.Error("same edits in a different order should share a fingerprint")
+	}
+
+	c, _ := ParseNative("\"a.go\":\nA\n\n\"b.go\":\nC\n")
+	if Fingerprint(a) == Fingerprint(c) {
+		t.Error("different edits should not share a fingerprint")
+	}
+}
@@ -2,6 +2,7 @@ package patch
 
 import (
 	"strings"
+	"unicode"
 )
 
 type Hunk struct {
@@ -38,18 +39,90 @@ func ParseHunks(content string) []Hunk {
 	return hunks
 }
 
+// MatchKind identifies which tier of ApplyHunk located a SEARCH block.
+type MatchKind int
+
+const (
+	MatchNone MatchKind = iota
+	MatchExact
+	MatchWhitespace
+	MatchSimilar
+	// MatchAlreadyApplied means SEARCH is gone but REPLACE is already in place,
+	// typically because the same patch was applied before.
+	MatchAlreadyApplied
+)
+
+func (k MatchKind) String() string {
+	switch k {
+	case MatchExact:
+		return "exact"
+	case MatchWhitespace:
+		return "whitespace"
+	case MatchSimilar:
+		return "similar"
+	case MatchAlreadyApplied:
+		return "already applied"
+	default:
+		return "none"
+	}
+}
+
+// MarshalText encodes the kind by name in JSON reports.
+func (k MatchKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Match describes where a hunk landed. Start and End are zero-based line
+// indices (End exclusive); Score is the similarity confidence from 0 to 1.
+type Match struct {
+	Kind  MatchKind `json:"kind"`
+	Start int       `json:"start"`
+	End   int       `json:"end"`
+	Score float64   `json:"score"`
+}
+
+// MatchOptions controls how far ApplyHunk may stray from an exact match.
+type MatchOptions struct {
+	// Strict disables the scored similarity tier.
+	Strict bool
+	// MinScore is the lowest similarity accepted by the scored tier.
+	MinScore float64
+}
+
+// DefaultMatchOptions is used by ApplyHunk and whenever goctx.json does not
+// configure matching.
+var DefaultMatchOptions = MatchOptions{MinScore: 0.8}
+
 func ApplyHunk(fileStr string, hunk Hunk) (string, bool) {
+	result, m := ApplyHunkWithOptions(fileStr, hunk, DefaultMatchOptions)
+	return result, m.Kind != MatchNone
+}
+
+// ApplyHunkWithOptions applies a single hunk and reports which tier matched.
+// A Match of kind MatchNone or MatchAlreadyApplied means the file was left
+// unchanged.
+func ApplyHunkWithOptions(fileStr string, hunk Hunk, opts MatchOptions) (string, Match) {
 	// Edge Case: Empty search string would match everywhere/nowhere meaningfully
 	if hunk.Search == "" {
-		return fileStr, false
+		return fileStr, Match{}
 	}
 
 	// 1. High-Integrity Exact Match
-	if strings.Contains(fileStr, hunk.Search) {
-		return strings.Replace(fileStr, hunk.Search, hunk.Replace, 1), true
+	if idx := strings.Index(fileStr, hunk.Search); idx != -1 {
+		// A REPLACE that extends SEARCH (an insertion) must not be added twice
+		if m, ok := appliedAround(fileStr, idx, hunk); ok {
+			return fileStr, m
+		}
+		start := strings.Count(fileStr[:idx], "\n")
+		m := Match{
+			Kind:  MatchExact,
+			Start: start,
+			End:   start + strings.Count(strings.TrimSuffix(hunk.Search, "\n"), "\n") + 1,
+			Score: 1,
+		}
+		return fileStr[:idx] + hunk.Replace + fileStr[idx+len(hunk.Search):], m
 	}
 
-	// 2. Resilient Fuzzy Match (Whitespace insensitive per line)
 	// Normalize line endings to LF for processing
 	normalize := func(s string) string {
 		return strings.ReplaceAll(s, "\r\n", "\n")
@@ -70,46 +143,182 @@ func ApplyHunk(fileStr string, hunk Hunk) (string, bool) {
 	fileLines := splitLines(fNorm)
 	searchLines := splitLines(sNorm)
 
-	// Guard against empty search or search longer than file
-	if len(searchLines) == 0 || len(searchLines) > len(fileLines) {
-		return fileStr, false
+	// Guard against empty search
+	if len(searchLines) == 0 {
+		return fileStr, Match{}
+	}
+
+	m := locateLines(fileLines, searchLines, splitLines(normalize(hunk.Replace)), opts)
+	if m.Kind == MatchNone || m.Kind == MatchAlreadyApplied {
+		return fileStr, m
+	}
+
+	// Construct result: [Lines before] + [New Content] + [Lines after]
+	head := fileLines[:m.Start]
+	tail := fileLines[m.End:]
+
+	var parts []string
+	if len(head) > 0 {
+		parts = append(parts, strings.Join(head, "\n"))
+	}
+	// Keep the replacement at the indentation of the region it replaces
+	parts = append(parts, reindent(hunk.Replace, searchLines, fileLines[m.Start:m.End], fileLines))
+	if len(tail) > 0 {
+		parts = append(parts, strings.Join(tail, "\n"))
+	}
+
+	result := strings.Join(parts, "\n")
+
+	// Integrity Check: If original file ended in a newline, preserve that
+	// property unless the replacement explicitly handles the end of file.
+	if strings.HasSuffix(fNorm, "\n") && !strings.HasSuffix(result, "\n") {
+		result += "\n"
 	}
 
-	for i := 0; i <= len(fileLines)-len(searchLines); i++ {
-		match := true
-		for j := 0; j < len(searchLines); j++ {
-			// Business Logic: Ignore leading/trailing whitespace variations introduced by LLM formatting
-			if strings.TrimSpace(fileLines[i+j]) != strings.TrimSpace(searchLines[j]) {
-				match = false
-				break
+	return result, m
+}
+
+// FindMatch reports where hunk would land in fileStr without applying it.
+func FindMatch(fileStr string, hunk Hunk, opts MatchOptions) Match {
+	_, m := ApplyHunkWithOptions(fileStr, hunk, opts)
+	return m
+}
+
+// locateLines runs the line-based tiers: whitespace-insensitive first, then
+// the already-applied check, then scored similarity unless disabled by opts.
+func locateLines(fileLines, searchLines, replaceLines []string, opts MatchOptions) Match {
+	// Both the already-applied check and the scored tier may need the most
+	// similar region; the scan is the expensive part, so it runs once.
+	var similar *Match
+	var ambiguous bool
+	nearest := func() (Match, bool) {
+		if similar == nil {
+			best, amb := bestSimilarRegion(fileLines, searchLines)
+			similar, ambiguous = &best, amb
+		}
+		return *similar, ambiguous
+	}
+
+	// 2. Resilient Fuzzy Match (Whitespace insensitive per line)
+	if i := findLines(fileLines, searchLines, 0); i != -1 {
+		// Same insertion guard as the exact tier, line by line
+		if off := findLines(replaceLines, searchLines, 0); off != -1 && len(replaceLines) > len(searchLines) {
+			if start := i - off; start >= 0 && linesEqualAt(fileLines, start, replaceLines) {
+				return Match{Kind: MatchAlreadyApplied, Start: start, End: start + len(replaceLines), Score: 1}
 			}
 		}
+		return Match{Kind: MatchWhitespace, Start: i, End: i + len(searchLines), Score: 1}
+	}
 
-		if match {
-			// Construct result: [Lines before] + [New Content] + [Lines after]
-			head := fileLines[:i]
-			tail := fileLines[i+len(searchLines):]
+	// A re-applied patch leaves REPLACE where SEARCH used to be. This runs before
+	// the similarity tier, which would otherwise match the edited region again.
+	// Only a unique occurrence counts, so a REPLACE of "}" proves nothing, and
+	// only where SEARCH was, so a hallucinated SEARCH is not mistaken for an
+	// edit made before. Strict matching never guesses.
+	if !opts.Strict && hasContent(replaceLines) {
+		if i := findLines(fileLines, replaceLines, 0); i != -1 && findLines(fileLines, replaceLines, i+1) == -1 &&
+			replacedInPlace(i, searchLines, replaceLines, opts, nearest) {
+			return Match{Kind: MatchAlreadyApplied, Start: i, End: i + len(replaceLines), Score: 1}
+		}
+	}
 
-			var parts []string
-			if len(head) > 0 {
-				parts = append(parts, strings.Join(head, "\n"))
-			}
-			parts = append(parts, hunk.Replace)
-			if len(tail) > 0 {
-				parts = append(parts, strings.Join(tail, "\n"))
-			}
+	// 3. Scored Similarity Match (tolerates hallucinated lines and small edits)
+	if opts.Strict || opts.MinScore <= 0 {
+		return Match{}
+	}
+	if best, ambiguous := nearest(); !ambiguous && best.Score >= opts.MinScore {
+		return best
+	}
+	return Match{}
+}
 
-			result := strings.Join(parts, "\n")
+// findLines returns the first index at or after from where lines occur in
+// fileLines ignoring leading and trailing whitespace, or -1.
+func findLines(fileLines, lines []string, from int) int {
+	for i := from; i <= len(fileLines)-len(lines); i++ {
+		if linesEqualAt(fileLines, i, lines) {
+			return i
+		}
+	}
+	return -1
+}
 
-			// Integrity Check: If original file ended in a newline, preserve that
-			// property unless the replacement explicitly handles the end of file.
-			if strings.HasSuffix(fNorm, "\n") && !strings.HasSuffix(result, "\n") {
-				result += "\n"
-			}
+func linesEqualAt(fileLines []string, at int, lines []string) bool {
+	if at+len(lines) > len(fileLines) {
+		return false
+	}
+	for j := range lines {
+		// Business Logic: Ignore leading/trailing whitespace variations introduced by LLM formatting
+		if strings.TrimSpace(fileLines[at+j]) != strings.TrimSpace(lines[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// replacedInPlace reports whether the REPLACE text found at the given line
+// stands where SEARCH used to be: REPLACE keeps lines of SEARCH around its
+// edit, which are then still next to it, or SEARCH matches best, by
+// similarity, right there; nearest returns that best region.
+func replacedInPlace(at int, searchLines, replaceLines []string, opts MatchOptions, nearest func() (Match, bool)) bool {
+	prefix := 0
+	for prefix < len(searchLines) && prefix < len(replaceLines) &&
+		strings.TrimSpace(searchLines[prefix]) == strings.TrimSpace(replaceLines[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(searchLines)-prefix && suffix < len(replaceLines)-prefix &&
+		strings.TrimSpace(searchLines[len(searchLines)-1-suffix]) == strings.TrimSpace(replaceLines[len(replaceLines)-1-suffix]) {
+		suffix++
+	}
+	if hasWords(searchLines[:prefix]) || hasWords(searchLines[len(searchLines)-suffix:]) {
+		return true
+	}
+
+	if opts.MinScore <= 0 {
+		return false
+	}
+	best, ambiguous := nearest()
+	return !ambiguous && best.Score >= opts.MinScore && best.Start < at+len(replaceLines) && at < best.End
+}
+
+// hasWords reports whether any line holds a letter or digit, unlike lines
+// of braces and punctuation, which occur everywhere.
+func hasWords(lines []string) bool {
+	for _, l := range lines {
+		if strings.IndexFunc(l, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) != -1 {
+			return true
+		}
+	}
+	return false
+}
 
-			return result, true
+func hasContent(lines []string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			return true
 		}
 	}
+	return false
+}
 
-	return fileStr, false
+// appliedAround reports whether the REPLACE text already surrounds the SEARCH
+// occurrence at idx. That happens when REPLACE contains SEARCH, e.g. when a
+// hunk only inserts lines next to its anchor and has been applied before.
+func appliedAround(fileStr string, idx int, hunk Hunk) (Match, bool) {
+	off := strings.Index(hunk.Replace, hunk.Search)
+	if off == -1 || hunk.Replace == hunk.Search {
+		return Match{}, false
+	}
+	start := idx - off
+	if start < 0 || !strings.HasPrefix(fileStr[start:], hunk.Replace) {
+		return Match{}, false
+	}
+	line := strings.Count(fileStr[:start], "\n")
+	return Match{
+		Kind:  MatchAlreadyApplied,
+		Start: line,
+		End:   line + strings.Count(strings.TrimSuffix(hunk.Replace, "\n"), "\n") + 1,
+		Score: 1,
+	}, true
 }
@@ -1,6 +1,7 @@
 package patch
 
 import (
+	"math/rand/v2"
 	"strings"
 	"testing"
 )
@@ -114,11 +115,11 @@ func TestApplyHunkEdgeCases(t *testing.T) {
 			name:     "Fuzzy Match with Indentation Shift",
 			original: "    func main() {\n        fmt.Println()\n    }",
 			hunk: Hunk{
-				Search: "func main() {\n    fmt.Println()\n}", // Different indentation
+				Search:  "func main() {\n    fmt.Println()\n}", // Different indentation
 				Replace: "func main() {\n    log.Printf(\"hi\")\n}",
 			},
 			expectOk: true,
-			expect:   "func main() {\n    log.Printf(\"hi\")\n}",
+			expect:   "    func main() {\n        log.Printf(\"hi\")\n    }",
 		},
 		{
 			name:     "Trailing Newline Mismatch",
@@ -158,3 +159,195 @@ func TestApplyHunkEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyHunkSimilarityTier(t *testing.T) {
+	original := "func load() {\n\tcfg := read()\n\tif cfg == nil {\n\t\treturn\n\t}\n\tuse(cfg)\n}\n"
+	hunk := Hunk{
+		// One hallucinated comment line that does not exist in the file
+		Search:  "func load() {\n\tcfg := read()\n\t// guard against missing config\n\tif cfg == nil {\n\t\treturn\n\t}",
+		Replace: "func load() {\n\tcfg := read()\n\tif cfg == nil {\n\t\tpanic(\"no config\")\n\t}",
+	}
+
+	result, m := ApplyHunkWithOptions(original, hunk, DefaultMatchOptions)
+	if m.Kind != MatchSimilar {
+		t.Fatalf("expected similarity match, got %v", m.Kind)
+	}
+	if m.Score < DefaultMatchOptions.MinScore || m.Score >= 1 {
+		t.Errorf("unexpected score %f", m.Score)
+	}
+	if m.Start != 0 || m.End != 5 {
+		t.Errorf("matched lines %d-%d, want 0-5", m.Start, m.End)
+	}
+	if !strings.Contains(result, "panic(\"no config\")") || !strings.Contains(result, "use(cfg)") {
+		t.Errorf("unexpected result:\n%s", result)
+	}
+}
+
+func TestApplyHunkSimilarityStrict(t *testing.T) {
+	original := "a := compute(x)\nb := a + 1\nreturn b\n"
+	hunk := Hunk{Search: "a := compute(y)\nb := a + 1", Replace: "b := 2"}
+
+	if _, m := ApplyHunkWithOptions(original, hunk, MatchOptions{Strict: true, MinScore: 0.5}); m.Kind != MatchNone {
+		t.Errorf("strict mode should reject similarity match, got %v", m.Kind)
+	}
+	if _, m := ApplyHunkWithOptions(original, hunk, MatchOptions{MinScore: 0.5}); m.Kind != MatchSimilar {
+		t.Errorf("expected similarity match, got %v", m.Kind)
+	}
+}
+
+func TestApplyHunkSimilarityAmbiguous(t *testing.T) {
+	original := "x := 1\ny := 2\n\nx := 1\ny := 2\n"
+	hunk := Hunk{Search: "x := 1\ny := 3", Replace: "z"}
+
+	result, m := ApplyHunkWithOptions(original, hunk, MatchOptions{MinScore: 0.5})
+	if m.Kind != MatchNone {
+		t.Errorf("expected ambiguous regions to be rejected, got %v at %d-%d", m.Kind, m.Start, m.End)
+	}
+	if result != original {
+		t.Errorf("file content should be unchanged")
+	}
+}
+
+func TestRuneDistanceMatchesDP(t *testing.T) {
+	// The bit-parallel distance must agree with the plain DP, including at
+	// the 64-rune word boundary and for runes outside ASCII
+	rng := rand.New(rand.NewPCG(1, 2))
+	alphabet := []rune("ab{}() \tx:=é世")
+	word := func() []rune {
+		r := make([]rune, rng.IntN(80))
+		for i := range r {
+			r[i] = alphabet[rng.IntN(len(alphabet))]
+		}
+		return r
+	}
+	for range 2000 {
+		a, b := word(), word()
+		if got, want := runeDistance(a, b), levenshtein(a, b); got != want {
+			t.Fatalf("runeDistance(%q, %q) = %d, want %d", string(a), string(b), got, want)
+		}
+	}
+}
+
+func TestApplyHunkReindent(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		hunk     Hunk
+		expect   string
+	}{
+		{
+			name:     "Tabs File De-indented Hunk",
+			original: "func a() {\n\tif ok {\n\t\trun()\n\t}\n}\n",
+			hunk: Hunk{
+				Search:  "if ok {\n\trun()\n}",
+				Replace: "if ok {\n\trun()\n\tlog()\n}",
+			},
+			expect: "func a() {\n\tif ok {\n\t\trun()\n\t\tlog()\n\t}\n}\n",
+		},
+		{
+			name:     "Spaces File De-indented Hunk",
+			original: "class A:\n  def f(self):\n    return 1\n",
+			hunk: Hunk{
+				Search:  "def f(self):\n  return 1",
+				Replace: "def f(self):\n  x = 1\n  return x",
+			},
+			expect: "class A:\n  def f(self):\n    x = 1\n    return x\n",
+		},
+		{
+			name:     "Mixed Tabs File With Space Hunk",
+			original: "func a() {\n\tfor {\n\t\tstep()\n\t}\n}\n",
+			hunk: Hunk{
+				Search:  "    for {\n        step()\n    }",
+				Replace: "    for {\n        step()\n        if done() {\n            break\n        }\n    }",
+			},
+			expect: "func a() {\n\tfor {\n\t\tstep()\n\t\tif done() {\n\t\t\tbreak\n\t\t}\n\t}\n}\n",
+		},
+		{
+			name:     "Over-indented Hunk",
+			original: "x()\ny()\n",
+			hunk: Hunk{
+				Search:  "        x()",
+				Replace: "        z()\n        x()",
+			},
+			expect: "z()\nx()\ny()\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ApplyHunk(tt.original, tt.hunk)
+			if !ok {
+				t.Fatal("expected hunk to apply")
+			}
+			if got != tt.expect {
+				t.Errorf("result = %q, want %q", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestApplyHunkAlreadyApplied(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		hunk     Hunk
+	}{
+		{
+			name:     "Replaced Block",
+			original: "func main() {\n\tfmt.Println(\"new\")\n}\n",
+			hunk:     Hunk{Search: "\tfmt.Println(\"old\")", Replace: "\tfmt.Println(\"new\")"},
+		},
+		{
+			name:     "Insertion After Anchor",
+			original: "import (\n\t\"fmt\"\n\t\"os\"\n)\n",
+			hunk:     Hunk{Search: "\t\"fmt\"\n", Replace: "\t\"fmt\"\n\t\"os\"\n"},
+		},
+		{
+			name:     "Insertion With Indentation Drift",
+			original: "func f() {\n\ta()\n\tb()\n}\n",
+			hunk:     Hunk{Search: "    a()", Replace: "    a()\n    b()"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, m := ApplyHunkWithOptions(tt.original, tt.hunk, DefaultMatchOptions)
+			if m.Kind != MatchAlreadyApplied {
+				t.Errorf("expected already applied, got %v", m.Kind)
+			}
+			if result != tt.original {
+				t.Errorf("file should be unchanged, got:\n%s", result)
+			}
+		})
+	}
+}
+
+func TestApplyHunkAlreadyAppliedNeedsUniqueReplace(t *testing.T) {
+	original := "if a {\n}\nif b {\n}\n"
+	hunk := Hunk{Search: "if c {\n\tx()", Replace: "}"}
+
+	if _, m := ApplyHunkWithOptions(original, hunk, MatchOptions{Strict: true}); m.Kind != MatchNone {
+		t.Errorf("a REPLACE found in several places proves nothing, got %v", m.Kind)
+	}
+}
+
+func TestApplyHunkAlreadyAppliedNeedsSearchContext(t *testing.T) {
+	// REPLACE occurs once, but in a function SEARCH has nothing to do with
+	original := "func a() error {\n\treturn nil\n}\n\nfunc b() error {\n\tx := compute()\n\treturn check(x)\n}\n"
+	hunk := Hunk{Search: "\tif err := load(); err != nil {\n\t\treturn fmt.Errorf(\"load: %w\", err)\n\t}", Replace: "\treturn nil"}
+
+	for _, opts := range []MatchOptions{DefaultMatchOptions, {Strict: true}} {
+		if _, m := ApplyHunkWithOptions(original, hunk, opts); m.Kind == MatchAlreadyApplied {
+			t.Errorf("strict=%v: a REPLACE elsewhere in the file was taken as applied", opts.Strict)
+		}
+	}
+
+	// The same REPLACE where SEARCH's surrounding lines still are is applied
+	hunk = Hunk{Search: "func a() error {\n\treturn errors.New(\"todo\")", Replace: "func a() error {\n\treturn nil"}
+	if _, m := ApplyHunkWithOptions(original, hunk, DefaultMatchOptions); m.Kind != MatchAlreadyApplied {
+		t.Errorf("expected already applied, got %v", m.Kind)
+	}
+	if _, m := ApplyHunkWithOptions(original, hunk, MatchOptions{Strict: true}); m.Kind != MatchNone {
+		t.Errorf("strict matching should not guess, got %v", m.Kind)
+	}
+}
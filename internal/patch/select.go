@@ -0,0 +1,112 @@
+package patch
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"goctx/internal/model"
+)
+
+// Selection records which parts of a pending patch the reviewer accepted,
+// keyed by file path. Surgical edits have one flag per hunk; full-file
+// writes and deletions have a single flag.
+type Selection map[string][]bool
+
+// NewSelection accepts every file and hunk of p.
+func NewSelection(p model.ProjectOutput) Selection {
+	sel := make(Selection, len(p.Files))
+	for path, content := range p.Files {
+		n := len(ParseHunks(content))
+		if n == 0 {
+			n = 1
+		}
+		flags := make([]bool, n)
+		for i := range flags {
+			flags[i] = true
+		}
+		sel[path] = flags
+	}
+	return sel
+}
+
+// Split divides p into the accepted part and the rejected remainder. Files
+// missing from the selection are treated as accepted.
+func (s Selection) Split(p model.ProjectOutput) (accepted, rejected model.ProjectOutput) {
+	accepted = model.ProjectOutput{ShortDescription: p.ShortDescription, Files: make(map[string]string)}
+	rejected = model.ProjectOutput{Files: make(map[string]string)}
+
+	for path, content := range p.Files {
+		flags, ok := s[path]
+		hunks := ParseHunks(content)
+
+		if len(hunks) == 0 {
+			if !ok || len(flags) == 0 || flags[0] {
+				accepted.Files[path] = content
+			} else {
+				rejected.Files[path] = content
+			}
+			continue
+		}
+
+		var keep, drop []Hunk
+		for i, h := range hunks {
+			if !ok || i >= len(flags) || flags[i] {
+				keep = append(keep, h)
+			} else {
+				drop = append(drop, h)
+			}
+		}
+		if len(keep) > 0 {
+			accepted.Files[path] = FormatHunks(keep)
+		}
+		if len(drop) > 0 {
+			rejected.Files[path] = FormatHunks(drop)
+		}
+	}
+
+	// A requested mode or move travels with whichever part is applied first:
+	// the remainder of a moved file is then addressed by its new path.
+	for path, mode := range p.Modes {
+		if _, ok := accepted.Files[path]; ok {
+			setMode(&accepted, path, mode)
+		} else {
+			setMode(&rejected, path, mode)
+		}
+	}
+	for path, from := range p.Renames {
+		if _, ok := accepted.Files[path]; ok {
+			setRename(&accepted, path, from)
+		} else {
+			setRename(&rejected, path, from)
+		}
+	}
+
+	if len(rejected.Files) > 0 {
+		rejected.ShortDescription = fmt.Sprintf("Remainder of %s", p.ShortDescription)
+	}
+	return accepted, rejected
+}
+
+// FormatHunks renders hunks back into native dialect SEARCH/REPLACE blocks.
+func FormatHunks(hunks []Hunk) string {
+	blocks := make([]string, len(hunks))
+	for i, h := range hunks {
+		blocks[i] = "<<<<<< SEARCH\n" + h.Search + "\n======\n" + h.Replace + "\n>>>>>> REPLACE"
+	}
+	return strings.Join(blocks, "\n")
+}
+
+func setMode(p *model.ProjectOutput, path string, mode os.FileMode) {
+	if p.Modes == nil {
+		p.Modes = make(map[string]os.FileMode)
+	}
+	p.Modes[path] = mode
+}
+
+func setRename(p *model.ProjectOutput, path, from string) {
+	if p.Renames == nil {
+		p.Renames = make(map[string]string)
+	}
+	p.Renames[path] = from
+}
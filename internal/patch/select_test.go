@@ -0,0 +1,54 @@
+package patch
+
+import (
+	"testing"
+
+	"goctx/internal/model"
+)
+
+func TestSelectionSplit(t *testing.T) {
+	p := model.ProjectOutput{
+		ShortDescription: "Updates to 3 files",
+		Files: map[string]string{
+			"a.go":   FormatHunks([]Hunk{{Search: "a1", Replace: "A1"}, {Search: "a2", Replace: "A2"}}),
+			"b.go":   "package b",
+			"old.go": "",
+		},
+	}
+
+	sel := NewSelection(p)
+	if len(sel["a.go"]) != 2 || len(sel["b.go"]) != 1 || len(sel["old.go"]) != 1 {
+		t.Fatalf("unexpected selection shape: %v", sel)
+	}
+	sel["a.go"][1] = false
+	sel["old.go"][0] = false
+
+	accepted, rejected := sel.Split(p)
+
+	if got := ParseHunks(accepted.Files["a.go"]); len(got) != 1 || got[0].Replace != "A1" {
+		t.Errorf("accepted a.go hunks = %+v", got)
+	}
+	if got := ParseHunks(rejected.Files["a.go"]); len(got) != 1 || got[0].Replace != "A2" {
+		t.Errorf("rejected a.go hunks = %+v", got)
+	}
+	if accepted.Files["b.go"] != "package b" {
+		t.Errorf("b.go should be accepted unchanged")
+	}
+	if _, ok := rejected.Files["old.go"]; !ok {
+		t.Errorf("rejected deletion should be kept in the remainder")
+	}
+	if _, ok := accepted.Files["old.go"]; ok {
+		t.Errorf("rejected deletion must not be applied")
+	}
+	if accepted.ShortDescription != p.ShortDescription || rejected.ShortDescription == "" {
+		t.Errorf("unexpected descriptions %q / %q", accepted.ShortDescription, rejected.ShortDescription)
+	}
+}
+
+func TestSelectionSplitAllAccepted(t *testing.T) {
+	p := model.ProjectOutput{Files: map[string]string{"a.go": "package a"}}
+	_, rejected := NewSelection(p).Split(p)
+	if len(rejected.Files) != 0 {
+		t.Errorf("expected empty remainder, got %v", rejected.Files)
+	}
+}
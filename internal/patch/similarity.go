@@ -0,0 +1,232 @@
+package patch
+
+import (
+	"slices"
+	"strings"
+)
+
+// similaritySlack is how many lines a candidate region may differ in height
+// from the SEARCH block, so a hallucinated or dropped line can still match.
+const similaritySlack = 2
+
+// scoreEpsilon treats two region scores as equal for ambiguity detection.
+const scoreEpsilon = 1e-9
+
+// similarityIndex caches per-line similarities between the file and the
+// SEARCH block so overlapping candidate windows do not recompute them.
+type similarityIndex struct {
+	file   [][]rune
+	search [][]rune
+	cache  [][]float64
+}
+
+func newSimilarityIndex(fileLines, searchLines []string) *similarityIndex {
+	idx := &similarityIndex{
+		file:   make([][]rune, len(fileLines)),
+		search: make([][]rune, len(searchLines)),
+		cache:  make([][]float64, len(fileLines)),
+	}
+	for i, l := range fileLines {
+		idx.file[i] = []rune(strings.TrimSpace(l))
+		row := make([]float64, len(searchLines))
+		for j := range row {
+			row[j] = -1
+		}
+		idx.cache[i] = row
+	}
+	for j, l := range searchLines {
+		idx.search[j] = []rune(strings.TrimSpace(l))
+	}
+	return idx
+}
+
+// line returns the similarity (0-1) of file line i and search line j,
+// ignoring leading and trailing whitespace.
+func (idx *similarityIndex) line(i, j int) float64 {
+	if v := idx.cache[i][j]; v >= 0 {
+		return v
+	}
+	v := runeSimilarity(idx.file[i], idx.search[j])
+	idx.cache[i][j] = v
+	return v
+}
+
+// region scores the file window [start, start+height) against the whole
+// SEARCH block using a line-level Levenshtein distance where substituting
+// one line for another costs their dissimilarity.
+func (idx *similarityIndex) region(start, height int) float64 {
+	m := len(idx.search)
+	prev := make([]float64, m+1)
+	curr := make([]float64, m+1)
+	for j := 0; j <= m; j++ {
+		prev[j] = float64(j)
+	}
+	for a := 1; a <= height; a++ {
+		curr[0] = float64(a)
+		for b := 1; b <= m; b++ {
+			sub := prev[b-1] + (1 - idx.line(start+a-1, b-1))
+			del := prev[b] + 1
+			ins := curr[b-1] + 1
+			curr[b] = min(sub, del, ins)
+		}
+		prev, curr = curr, prev
+	}
+	longest := max(height, m)
+	return 1 - prev[m]/float64(longest)
+}
+
+// bestSimilarRegion scans every window of the file whose height is within
+// similaritySlack lines of the SEARCH block and returns the highest scoring
+// one. A second, non-overlapping window with the same score makes the result
+// ambiguous, since guessing between them could edit the wrong code.
+func bestSimilarRegion(fileLines, searchLines []string) (best Match, ambiguous bool) {
+	if len(searchLines) == 0 || len(fileLines) == 0 {
+		return Match{}, false
+	}
+
+	idx := newSimilarityIndex(fileLines, searchLines)
+	type candidate struct {
+		start, end int
+		score      float64
+	}
+	var scored []candidate
+	top := candidate{score: -1}
+
+	// Prefer the exact height on ties by visiting it first.
+	heights := []int{len(searchLines)}
+	for d := 1; d <= similaritySlack; d++ {
+		heights = append(heights, len(searchLines)-d, len(searchLines)+d)
+	}
+
+	for _, h := range heights {
+		if h < 1 || h > len(fileLines) {
+			continue
+		}
+		for i := 0; i+h <= len(fileLines); i++ {
+			c := candidate{start: i, end: i + h, score: idx.region(i, h)}
+			scored = append(scored, c)
+			if c.score > top.score+scoreEpsilon {
+				top = c
+			}
+		}
+	}
+
+	if top.score < 0 {
+		return Match{}, false
+	}
+
+	for _, c := range scored {
+		overlaps := c.start < top.end && top.start < c.end
+		if !overlaps && c.score >= top.score-scoreEpsilon {
+			ambiguous = true
+			break
+		}
+	}
+
+	return Match{Kind: MatchSimilar, Start: top.start, End: top.end, Score: top.score}, ambiguous
+}
+
+// runeSimilarity returns 1 - normalized Levenshtein distance of a and b.
+func runeSimilarity(a, b []rune) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	if slices.Equal(a, b) {
+		return 1
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	return 1 - float64(runeDistance(a, b))/float64(max(len(a), len(b)))
+}
+
+// runeDistance returns the Levenshtein distance of a and b. Lines of up to
+// 64 runes, nearly all of them in source code, use the bit-parallel form,
+// which keeps a whole DP column in one word and makes the similarity scan
+// of a large file affordable.
+func runeDistance(a, b []rune) int {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(a) == 0 {
+		return len(b)
+	}
+	if len(a) > 64 {
+		return levenshtein(a, b)
+	}
+
+	var ascii [128]uint64
+	for i, r := range a {
+		if r < 128 {
+			ascii[r] |= 1 << i
+		}
+	}
+	eq := func(r rune) uint64 {
+		if r < 128 {
+			return ascii[r]
+		}
+		var bits uint64
+		for i, p := range a {
+			if p == r {
+				bits |= 1 << i
+			}
+		}
+		return bits
+	}
+
+	last := uint64(1) << (len(a) - 1)
+	pv, mv := ^uint64(0), uint64(0)
+	dist := len(a)
+	for _, r := range b {
+		e := eq(r)
+		xv := e | mv
+		xh := (((e & pv) + pv) ^ pv) | e
+		ph := mv | ^(xh | pv)
+		mh := pv & xh
+		if ph&last != 0 {
+			dist++
+		} else if mh&last != 0 {
+			dist--
+		}
+		ph = ph<<1 | 1
+		mh <<= 1
+		pv = mh | ^(xv | ph)
+		mv = ph & xv
+	}
+	return dist
+}
+
+// levenshtein is the plain two-row DP behind runeDistance for long lines.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j-1]+cost, prev[j]+1, curr[j-1]+1)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// NearestRegion returns the region of fileStr most similar to the hunk's
+// SEARCH block regardless of any threshold, and whether another region
+// scored just as well. It is used to explain failed matches.
+func NearestRegion(fileStr string, hunk Hunk) (Match, bool) {
+	split := func(s string) []string {
+		s = strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, "\n")
+	}
+	return bestSimilarRegion(split(fileStr), split(hunk.Search))
+}
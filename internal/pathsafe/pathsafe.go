@@ -0,0 +1,110 @@
+// Package pathsafe validates paths taken from untrusted input, such as a
+// patch or the trash index, before they are written below a project root.
+package pathsafe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrEscapesRoot is wrapped by every Resolve error for a path that would end
+// up outside the project root.
+var ErrEscapesRoot = errors.New("path escapes the project root")
+
+// Resolve validates path and returns it relative to root in slash form.
+// Absolute paths are accepted only inside root. The path must not climb with
+// "..", leave root through a symlink, name root itself, touch .git at any
+// depth or fall under one of the reserved top-level names.
+func Resolve(root, path string, reserved ...string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		return "", errors.New("empty path")
+	}
+	if strings.ContainsRune(path, 0) {
+		return "", errors.New("path contains a NUL byte")
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("%w: it must not contain \"..\"", ErrEscapesRoot)
+		}
+	}
+
+	rootAbs, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve project root: %w", err)
+	}
+	target := filepath.Join(rootAbs, path)
+	if filepath.IsAbs(path) {
+		target = filepath.Clean(path)
+	}
+
+	rel, err := filepath.Rel(rootAbs, target)
+	if err != nil || !IsLocal(rel) {
+		return "", ErrEscapesRoot
+	}
+	if rel == "." {
+		return "", errors.New("path names the project root itself")
+	}
+
+	// Follow symlinks of whatever part of the path already exists
+	realRoot, err := EvalExisting(rootAbs)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve project root: %w", err)
+	}
+	realTarget, err := EvalExisting(target)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve path: %w", err)
+	}
+	if realRel, err := filepath.Rel(realRoot, realTarget); err != nil || !IsLocal(realRel) || realRel == "." {
+		return "", fmt.Errorf("%w through a symlink", ErrEscapesRoot)
+	}
+
+	rel = filepath.ToSlash(rel)
+	if name := Reserved(rel, reserved...); name != "" {
+		return "", fmt.Errorf("%s is a protected path", name)
+	}
+	return rel, nil
+}
+
+// Reserved returns the reserved name the slash-separated rel falls under, or
+// "". .git is rejected at any depth so nested repositories are covered too.
+func Reserved(rel string, reserved ...string) string {
+	elems := strings.Split(rel, "/")
+	for _, name := range reserved {
+		if elems[0] == name {
+			return name
+		}
+	}
+	for _, elem := range elems {
+		if elem == ".git" {
+			return ".git"
+		}
+	}
+	return ""
+}
+
+// IsLocal reports whether a filepath.Rel result stays below its base.
+func IsLocal(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// EvalExisting resolves symlinks in the longest existing prefix of path and
+// appends the remaining, not yet created, elements unchanged.
+func EvalExisting(path string) (string, error) {
+	var missing []string
+	for p := path; ; p = filepath.Dir(p) {
+		if _, err := os.Lstat(p); err == nil {
+			real, err := filepath.EvalSymlinks(p)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(append([]string{real}, missing...)...), nil
+		}
+		if filepath.Dir(p) == p {
+			return path, nil
+		}
+		missing = append([]string{filepath.Base(p)}, missing...)
+	}
+}
@@ -0,0 +1,48 @@
+package pathsafe
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "project")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(parent, filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, want string
+		escapes    bool
+	}{
+		{path: "a/b.go", want: "a/b.go"},
+		{path: filepath.Join(root, "c.go"), want: "c.go"},
+		{path: "../x.go", escapes: true},
+		{path: filepath.Join(parent, "x.go"), escapes: true},
+		{path: "out/x.go", escapes: true},
+		{path: "."},
+		{path: ".git/config"},
+		{path: "sub/.git/HEAD"},
+		{path: ".trash/x"},
+	}
+	for _, tt := range tests {
+		got, err := Resolve(root, tt.path, ".trash")
+		if tt.want != "" {
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Resolve(%q) = %q, want rejection", tt.path, got)
+		} else if escapes := errors.Is(err, ErrEscapesRoot); escapes != tt.escapes {
+			t.Errorf("Resolve(%q): %v, escapes root = %v, want %v", tt.path, err, escapes, tt.escapes)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackagesPlaceholder in a step's command is replaced by the Go packages a
+// patch affects, e.g. "go test {{packages}}".
+const PackagesPlaceholder = "{{packages}}"
+
+// AllPackages stands in for the affected packages on a manual run or when
+// they cannot be worked out.
+const AllPackages = "./..."
+
+// goPackage is the part of `go list -json` output needed to map files to
+// packages and packages to their importers.
+type goPackage struct {
+	ImportPath   string
+	Dir          string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// AffectedPackages maps touched files (relative to root) to the packages
+// of root's module that contain them, plus every module package importing
+// those, directly or through tests. It returns an error when a touched Go
+// file or module file cannot be tied to a package, since verifying only a
+// subset would then be unsafe.
+func AffectedPackages(ctx context.Context, root string, touched []string) ([]string, error) {
+	if len(touched) == 0 {
+		return nil, fmt.Errorf("no changed files")
+	}
+	pkgs, err := listPackages(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	absRoot, err := canonicalDir(root)
+	if err != nil {
+		return nil, err
+	}
+	byDir := make(map[string]*goPackage)
+	importers := make(map[string][]string)
+	for i := range pkgs {
+		p := &pkgs[i]
+		if dir, err := canonicalDir(p.Dir); err == nil {
+			byDir[dir] = p
+		}
+		seen := make(map[string]bool)
+		for _, imp := range append(append(append([]string{}, p.Imports...), p.TestImports...), p.XTestImports...) {
+			if !seen[imp] && imp != p.ImportPath {
+				seen[imp] = true
+				importers[imp] = append(importers[imp], p.ImportPath)
+			}
+		}
+	}
+
+	affected := make(map[string]bool)
+	var queue []string
+	for _, file := range touched {
+		file = filepath.FromSlash(file)
+		switch filepath.Base(file) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum":
+			return nil, fmt.Errorf("%s changed", file)
+		}
+		p := owningPackage(byDir, absRoot, filepath.Join(absRoot, filepath.Dir(file)))
+		if p == nil {
+			if filepath.Ext(file) == ".go" {
+				return nil, fmt.Errorf("%s is not in a package of this module", file)
+			}
+			continue
+		}
+		if !affected[p.ImportPath] {
+			affected[p.ImportPath] = true
+			queue = append(queue, p.ImportPath)
+		}
+	}
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("no Go package changed")
+	}
+
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, importer := range importers[pkg] {
+			if !affected[importer] {
+				affected[importer] = true
+				queue = append(queue, importer)
+			}
+		}
+	}
+
+	result := make([]string, 0, len(affected))
+	for pkg := range affected {
+		result = append(result, pkg)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// owningPackage finds the package in dir or, for files such as testdata
+// and embedded assets, the nearest parent directory within root.
+func owningPackage(byDir map[string]*goPackage, root, dir string) *goPackage {
+	for {
+		if p := byDir[dir]; p != nil {
+			return p
+		}
+		if dir == root || !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func listPackages(ctx context.Context, root string) ([]goPackage, error) {
+	cmd := exec.CommandContext(ctx, "go", "list", "-e", "-json=ImportPath,Dir,Imports,TestImports,XTestImports", "./...")
+	cmd.Dir = root
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var pkgs []goPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p goPackage
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("go list: %w", err)
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+func canonicalDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	return abs, nil
+}
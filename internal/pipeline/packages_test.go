@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"goctx/internal/model"
+)
+
+// newModule lays out a module where b imports a, c's tests import b, and d
+// stands alone.
+func newModule(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	root := t.TempDir()
+	files := map[string]string{
+		"go.mod":            "module example.com/m\n\ngo 1.21\n",
+		"a/a.go":            "package a\n\nconst A = 1\n",
+		"a/testdata/in.txt": "fixture\n",
+		"b/b.go":            "package b\n\nimport \"example.com/m/a\"\n\nconst B = a.A\n",
+		"c/c.go":            "package c\n",
+		"c/c_test.go":       "package c\n\nimport (\n\t\"testing\"\n\n\t\"example.com/m/b\"\n)\n\nfunc TestC(t *testing.T) { _ = b.B }\n",
+		"d/d.go":            "package d\n",
+		"docs/README.md":    "docs\n",
+		"d/internal/e/e.go": "package e\n",
+	}
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestAffectedPackages(t *testing.T) {
+	root := newModule(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		touched []string
+		want    []string
+		wantErr string
+	}{
+		{[]string{"a/a.go"}, []string{"example.com/m/a", "example.com/m/b", "example.com/m/c"}, ""},
+		{[]string{"a/testdata/in.txt"}, []string{"example.com/m/a", "example.com/m/b", "example.com/m/c"}, ""},
+		{[]string{"d/d.go", "docs/README.md"}, []string{"example.com/m/d"}, ""},
+		{[]string{"docs/README.md"}, nil, "no Go package changed"},
+		{[]string{"go.mod"}, nil, "go.mod changed"},
+		{[]string{"tools/gen.go"}, nil, "not in a package"},
+	}
+	for _, tt := range tests {
+		got, err := AffectedPackages(ctx, root, tt.touched)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("AffectedPackages(%v) error = %v, want %q", tt.touched, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AffectedPackages(%v) = %v, %v, want %v", tt.touched, got, err, tt.want)
+		}
+	}
+}
+
+func TestRunSubstitutesPackages(t *testing.T) {
+	root := newModule(t)
+	steps := model.Scripts{{Name: "test", Run: "echo {{packages}}"}}
+
+	var notes []string
+	results := Run(context.Background(), root, steps, []string{"b/b.go"}, Hooks{
+		OnLog: func(step model.Step, line string) { notes = append(notes, line) },
+	})
+	if got := strings.TrimSpace(string(results[0].Output)); got != "example.com/m/b example.com/m/c" {
+		t.Errorf("targeted run got %q", got)
+	}
+	if len(notes) == 0 || notes[0] != "Verifying 2 affected package(s)" {
+		t.Errorf("targeted run should say what it verifies, got %q", notes)
+	}
+
+	results = Run(context.Background(), root, steps, []string{"go.mod"}, Hooks{})
+	if got := strings.TrimSpace(string(results[0].Output)); got != AllPackages {
+		t.Errorf("fallback run got %q", got)
+	}
+
+	if res := RunStep(context.Background(), root, steps[0], nil); strings.TrimSpace(string(res.Output)) != AllPackages {
+		t.Errorf("manual run got %q", res.Output)
+	}
+}
@@ -0,0 +1,255 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goctx/internal/gotest"
+	"goctx/internal/model"
+	"goctx/internal/runner"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Result records how one step of the pipeline went.
+type Result struct {
+	Step     model.Step
+	Output   []byte
+	Err      error
+	Skipped  bool
+	Duration time.Duration
+	// Tests holds per-test results when the step ran `go test -json`.
+	Tests *gotest.Report
+	// Accepted marks a failure that Hooks.Accept let through.
+	Accepted bool
+}
+
+// Failed reports whether the step ran and failed, timed out or was
+// cancelled.
+func (r Result) Failed() bool {
+	return !r.Skipped && r.Err != nil
+}
+
+// TimedOut reports whether the step was stopped by its timeout.
+func (r Result) TimedOut() bool {
+	var timeout *TimeoutError
+	return errors.As(r.Err, &timeout)
+}
+
+// Canceled reports whether the step was stopped because the pipeline was
+// cancelled.
+func (r Result) Canceled() bool {
+	return errors.Is(r.Err, context.Canceled)
+}
+
+// TimeoutError reports a step stopped by its own timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Hooks receive pipeline events. Any of them may be nil.
+type Hooks struct {
+	// OnStart is called before a step runs.
+	OnStart func(step model.Step)
+	// OnLog receives each output line of the running step.
+	OnLog func(step model.Step, line string)
+	// OnDone is called after a step ran or was skipped.
+	OnDone func(res Result)
+	// Accept may let a failed required step through, for example one that
+	// failed the same way before the patch. Accepted failures do not stop
+	// the pipeline. Cancelled steps are never offered.
+	Accept func(res Result) bool
+}
+
+// Label names a step in logs and buttons, falling back to its command.
+func Label(step model.Step) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	return step.Run
+}
+
+// Applies reports whether step should verify a patch touching the given
+// files. A nil list means a manual run, which runs every step.
+func Applies(step model.Step, touched []string) bool {
+	if len(step.Paths) == 0 || touched == nil {
+		return true
+	}
+	for _, file := range touched {
+		for _, pattern := range step.Paths {
+			if matchGlob(pattern, filepath.ToSlash(file)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Run executes steps in order from root, skipping those whose path globs
+// miss every touched file. It stops at the first required step that fails
+// or when ctx is cancelled, and returns the results so far; see Failure.
+// PackagesPlaceholder in a command becomes the packages the touched files
+// affect, or AllPackages when that cannot be worked out.
+func Run(ctx context.Context, root string, steps model.Scripts, touched []string, hooks Hooks) []Result {
+	var results []Result
+	var packages string
+	for _, step := range steps {
+		if strings.TrimSpace(step.Run) == "" {
+			continue
+		}
+		var res Result
+		if !Applies(step, touched) {
+			res = Result{Step: step, Skipped: true}
+		} else {
+			if strings.Contains(step.Run, PackagesPlaceholder) {
+				if packages == "" {
+					var note string
+					packages, note = resolvePackages(ctx, root, touched)
+					if note != "" && hooks.OnLog != nil {
+						hooks.OnLog(step, note)
+					}
+				}
+				step.Run = strings.ReplaceAll(step.Run, PackagesPlaceholder, packages)
+			}
+			if hooks.OnStart != nil {
+				hooks.OnStart(step)
+			}
+			res = RunStep(ctx, root, step, func(line string) {
+				if hooks.OnLog != nil {
+					hooks.OnLog(step, line)
+				}
+			})
+		}
+		if res.Failed() && !step.Advisory && !res.Canceled() && hooks.Accept != nil {
+			res.Accepted = hooks.Accept(res)
+		}
+		if hooks.OnDone != nil {
+			hooks.OnDone(res)
+		}
+		results = append(results, res)
+		if res.Failed() && (!step.Advisory && !res.Accepted || ctx.Err() != nil) {
+			break
+		}
+	}
+	return results
+}
+
+// resolvePackages fills PackagesPlaceholder for touched files, with a note
+// explaining a fallback to AllPackages.
+func resolvePackages(ctx context.Context, root string, touched []string) (string, string) {
+	if touched == nil {
+		return AllPackages, ""
+	}
+	pkgs, err := AffectedPackages(ctx, root, touched)
+	if err != nil {
+		return AllPackages, fmt.Sprintf("Verifying all packages: %v", err)
+	}
+	return strings.Join(pkgs, " "), fmt.Sprintf("Verifying %d affected package(s)", len(pkgs))
+}
+
+// Failure returns the first required step that failed, or the step that
+// was running when the pipeline was cancelled. It returns nil if the
+// pipeline passed.
+func Failure(results []Result) *Result {
+	for i, res := range results {
+		if res.Failed() && (!res.Step.Advisory && !res.Accepted || res.Canceled()) {
+			return &results[i]
+		}
+	}
+	return nil
+}
+
+// RunStep runs a single step, honouring its directory, environment and
+// timeout. A PackagesPlaceholder still in the command means AllPackages.
+func RunStep(ctx context.Context, root string, step model.Step, onLog func(string)) Result {
+	step.Run = strings.ReplaceAll(step.Run, PackagesPlaceholder, AllPackages)
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(step.Timeout))
+		defer cancel()
+	}
+
+	dir := root
+	if step.Dir != "" {
+		dir = filepath.Join(root, step.Dir)
+	}
+
+	var env []string
+	for k, v := range step.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+
+	start := time.Now()
+	out, err := runner.RunContext(ctx, dir, step.Run, env, func(line string) {
+		if onLog != nil {
+			if text, ok := readableLine(line); ok {
+				onLog(text)
+			}
+		}
+	})
+	if errors.Is(err, context.DeadlineExceeded) && step.Timeout > 0 {
+		err = &TimeoutError{Timeout: time.Duration(step.Timeout)}
+	}
+	res := Result{Step: step, Output: out, Err: err, Duration: time.Since(start)}
+	if tests, ok := gotest.Parse(out); ok {
+		res.Tests = tests
+	}
+	return res
+}
+
+// readableLine turns a `go test -json` event back into the text go test
+// would have printed; other events are dropped. Plain lines pass through.
+func readableLine(line string) (string, bool) {
+	ev, ok := gotest.ParseEvent(line)
+	if !ok {
+		return line, true
+	}
+	if ev.Action != "output" && ev.Action != "build-output" {
+		return "", false
+	}
+	return strings.TrimRight(ev.Output, "\n"), true
+}
+
+// matchGlob matches a slash-separated path against a glob. Patterns
+// without a slash match the file name in any directory; "**" matches any
+// number of directories.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
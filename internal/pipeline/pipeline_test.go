@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"goctx/internal/model"
+)
+
+func TestApplies(t *testing.T) {
+	tests := []struct {
+		paths   []string
+		touched []string
+		want    bool
+	}{
+		{nil, []string{"main.go"}, true},
+		{[]string{"*.go"}, nil, true},
+		{[]string{"*.go"}, []string{"internal/a/a.go"}, true},
+		{[]string{"*.go"}, []string{"README.md"}, false},
+		{[]string{"web/**"}, []string{"web/src/app.ts"}, true},
+		{[]string{"web/**/*.ts"}, []string{"web/app.ts"}, true},
+		{[]string{"web/**/*.ts"}, []string{"api/app.ts"}, false},
+		{[]string{"docs/*.md"}, []string{"docs/guide/intro.md"}, false},
+		{[]string{"*.md", "*.go"}, []string{"x.txt", "y.go"}, true},
+		{[]string{"*.go"}, []string{}, false},
+	}
+	for _, tt := range tests {
+		if got := Applies(model.Step{Run: "true", Paths: tt.paths}, tt.touched); got != tt.want {
+			t.Errorf("Applies(%v, %v) = %v, want %v", tt.paths, tt.touched, got, tt.want)
+		}
+	}
+}
+
+func TestRunStopsAtRequiredFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	steps := model.Scripts{
+		{Name: "lint", Run: "exit 3", Advisory: true},
+		{Name: "docs", Run: "exit 1", Paths: []string{"*.md"}},
+		{Name: "build", Run: "exit 1"},
+		{Name: "test", Run: "echo never"},
+	}
+	var started []string
+	results := Run(context.Background(), t.TempDir(), steps, []string{"main.go"}, Hooks{
+		OnStart: func(step model.Step) { started = append(started, step.Name) },
+	})
+
+	if strings.Join(started, ",") != "lint,build" {
+		t.Errorf("started %v, want lint and build", started)
+	}
+	if len(results) != 3 || !results[0].Failed() || !results[1].Skipped {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if f := Failure(results); f == nil || f.Step.Name != "build" {
+		t.Errorf("Failure() = %+v, want the build step", f)
+	}
+}
+
+func TestRunStepDirEnvAndTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	res := RunStep(context.Background(), root, model.Step{
+		Run: `echo "$GREETING from $(basename "$PWD")"`,
+		Dir: "web",
+		Env: map[string]string{"GREETING": "hello"},
+	}, nil)
+	if res.Err != nil || strings.TrimSpace(string(res.Output)) != "hello from web" {
+		t.Errorf("got %q, %v", res.Output, res.Err)
+	}
+
+	res = RunStep(context.Background(), root, model.Step{
+		Run:     "exec sleep 5",
+		Timeout: model.Duration(100 * time.Millisecond),
+	}, nil)
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "timed out after 100ms") {
+		t.Errorf("expected a timeout, got %v", res.Err)
+	}
+}
@@ -2,6 +2,7 @@ package renderer
 
 import (
 	"fmt"
+	"goctx/internal/apply"
 	"goctx/internal/model"
 	"goctx/internal/patch"
 	"os"
@@ -9,10 +10,14 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"github.com/gotk3/gotk3/gtk"
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
-func (r *Renderer) RenderDiff(p model.ProjectOutput, title string) {
+// RenderDiff previews a pending patch beside report, its check against the
+// workspace. When sel is non-nil, every file and hunk gets a check button
+// bound to the selection so the reviewer can pick which parts to apply.
+func (r *Renderer) RenderDiff(p model.ProjectOutput, report *apply.ApplyReport, title string, sel patch.Selection) {
 	*r.isLoading = true
 	defer func() { *r.isLoading = false }()
 
@@ -25,6 +30,13 @@ func (r *Renderer) RenderDiff(p model.ProjectOutput, title string) {
 	// 	r.statsBuf.Insert(r.statsBuf.GetEndIter(), "---\n\n")
 	// }
 
+	// The report comes from the same engine the CLI's --check uses, so both
+	// agree
+	checks := make(map[string]apply.FileReport)
+	for _, f := range report.Files {
+		checks[f.Path] = f
+	}
+
 	dmp := diffmatchpatch.New()
 	var keys []string
 	for k := range p.Files {
@@ -41,9 +53,28 @@ func (r *Renderer) RenderDiff(p model.ProjectOutput, title string) {
 			continue
 		}
 
+		// Hunk toggles are collected so the file toggle can flip them all
+		var hunkToggles []*gtk.CheckButton
+		if flags := sel[path]; len(flags) > 0 {
+			r.insertToggle(flags[0], func(active bool) {
+				for j := range flags {
+					flags[j] = active
+				}
+				for _, cb := range hunkToggles {
+					cb.SetActive(active)
+				}
+			})
+		}
 		r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), fmt.Sprintf("FILE: %s\n", path), r.GetTag("header"))
 
-		oldData, err := os.ReadFile(path)
+		// A moved file is diffed against its old location
+		source := path
+		if from := p.Renames[path]; from != "" {
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), fmt.Sprintf("RENAME: %s -> %s\n", from, path), r.GetTag("header"))
+			source = from
+		}
+
+		oldData, err := os.ReadFile(source)
 		var oldStr string
 		if err != nil {
 			if os.IsNotExist(err) {
@@ -53,9 +84,21 @@ func (r *Renderer) RenderDiff(p model.ProjectOutput, title string) {
 			oldStr = string(oldData)
 		}
 
+		check := checks[path]
+		if check.Protected {
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "PROTECTED: applying asks for confirmation\n", r.GetTag("deleted"))
+		}
+		if check.Mode != "" {
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), fmt.Sprintf("MODE: %s\n", check.Mode), r.GetTag("header"))
+		}
 		hunks := patch.ParseHunks(content)
 		if len(hunks) > 0 {
-			for _, h := range hunks {
+			for hi, h := range hunks {
+				if flags := sel[path]; hi < len(flags) {
+					hunkToggles = append(hunkToggles, r.insertToggle(flags[hi], func(active bool) {
+						flags[hi] = active
+					}))
+				}
 				r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "--- SURGICAL MODIFICATION ---\n", r.GetTag("header"))
 
 				// Show granular changes inside the block
@@ -79,13 +122,27 @@ func (r *Renderer) RenderDiff(p model.ProjectOutput, title string) {
 				}
 
 				// Check if the block actually matches what's on disk
-				if oldStr != "" && !strings.Contains(oldStr, h.Search) {
-					r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "\n\nERROR: SEARCH block not found in target file!\n", r.GetTag("deleted"))
-				} else if oldStr != "" {
-					r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "\n\nREADY: Hunk match validated.\n", r.GetTag("added"))
+				r.statsBuf.Insert(r.statsBuf.GetEndIter(), "\n\n")
+				if hi < len(check.Hunks) {
+					r.renderHunkStatus(check.Hunks[hi])
+				} else if check.Blocked() {
+					r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "ERROR: "+check.Error+"\n", r.GetTag("deleted"))
 				}
 				r.statsBuf.Insert(r.statsBuf.GetEndIter(), "\n---\n\n")
 			}
+			if msg := check.FileError(); msg != "" && len(check.Hunks) > 0 && check.Blocked() {
+				r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "ERROR: "+msg+"\n\n", r.GetTag("deleted"))
+			}
+		} else if check.Action == "delete" {
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "(FILE WILL BE MOVED TO .trash)\n\n", r.GetTag("deleted"))
+		} else if check.Action == "already deleted" {
+			r.statsBuf.Insert(r.statsBuf.GetEndIter(), "(ALREADY DELETED - nothing to do)\n\n")
+		} else if check.Blocked() && check.Diff == "" {
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "ERROR: "+check.Error+"\n\n", r.GetTag("deleted"))
+		} else if strings.TrimSpace(content) == "" && p.Renames[path] != "" {
+			r.statsBuf.Insert(r.statsBuf.GetEndIter(), "(MOVE ONLY - content unchanged)\n\n")
+		} else if _, hasMode := p.Modes[path]; hasMode && strings.TrimSpace(content) == "" {
+			r.statsBuf.Insert(r.statsBuf.GetEndIter(), "(PERMISSIONS ONLY - content unchanged)\n\n")
 		} else {
 			// standard diff for full files
 			diffs := dmp.DiffMain(oldStr, content, false)
@@ -105,6 +162,39 @@ func (r *Renderer) RenderDiff(p model.ProjectOutput, title string) {
 				}
 			}
 			r.statsBuf.Insert(r.statsBuf.GetEndIter(), "\n\n")
+			if check.Blocked() {
+				r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "ERROR: "+check.Error+"\n\n", r.GetTag("deleted"))
+			}
 		}
 	}
 }
+
+// renderHunkStatus explains how a hunk matches the file on disk.
+func (r *Renderer) renderHunkStatus(h apply.HunkReport) {
+	end := r.statsBuf.GetEndIter()
+	switch h.Status {
+	case apply.HunkExact:
+		r.statsBuf.InsertWithTag(end, fmt.Sprintf("READY: Hunk match validated (lines %d-%d).\n", h.Match.Start+1, h.Match.End), r.GetTag("added"))
+	case apply.HunkFuzzy:
+		r.statsBuf.InsertWithTag(end, fmt.Sprintf("READY: Approximate match at lines %d-%d (%.0f%% confidence).\n", h.Match.Start+1, h.Match.End, h.Match.Score*100), r.GetTag("added"))
+	case apply.HunkAlreadyApplied:
+		r.statsBuf.InsertWithTag(end, "ALREADY APPLIED: REPLACE text is already present.\n", r.GetTag("header"))
+	default:
+		r.statsBuf.InsertWithTag(end, fmt.Sprintf("ERROR: SEARCH block %s in target file!\n", h.Status), r.GetTag("deleted"))
+		if h.NearMiss != nil {
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), fmt.Sprintf("Closest region (lines %d-%d, %.0f%% similar):\n", h.NearMiss.Start+1, h.NearMiss.End, h.NearMiss.Score*100), r.GetTag("header"))
+			r.statsBuf.Insert(r.statsBuf.GetEndIter(), h.NearMissText+"\n")
+		}
+	}
+}
+
+// insertToggle anchors a check button at the end of the buffer.
+func (r *Renderer) insertToggle(active bool, onToggle func(bool)) *gtk.CheckButton {
+	anchor, _ := r.statsBuf.CreateChildAnchor(r.statsBuf.GetEndIter())
+	cb, _ := gtk.CheckButtonNew()
+	cb.SetActive(active)
+	cb.Connect("toggled", func() { onToggle(cb.GetActive()) })
+	r.statsView.AddChildAtAnchor(cb, anchor)
+	cb.Show()
+	return cb
+}
@@ -8,15 +8,16 @@ import (
 
 type Renderer struct {
 	statsBuf     *gtk.TextBuffer
+	statsView    *gtk.TextView
 	isLoading    *bool
 	statusLabel  *gtk.Label
 	updateStatus func(statusLabel *gtk.Label, m string)
 }
 
-func NewRenderer(statsBuf *gtk.TextBuffer, isLoading *bool, statusLabel *gtk.Label,
+func NewRenderer(statsBuf *gtk.TextBuffer, statsView *gtk.TextView, isLoading *bool, statusLabel *gtk.Label,
 	updateStatus func(statusLabel *gtk.Label, m string)) *Renderer {
 	return &Renderer{
-		statsBuf, isLoading, statusLabel, updateStatus,
+		statsBuf, statsView, isLoading, statusLabel, updateStatus,
 	}
 }
 
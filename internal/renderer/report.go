@@ -0,0 +1,84 @@
+package renderer
+
+import (
+	"fmt"
+	"goctx/internal/apply"
+	"goctx/internal/gotest"
+	"strings"
+)
+
+// RenderApplyReport shows the per-file and per-hunk outcome of an apply,
+// followed by the error that stopped it, if any.
+func (r *Renderer) RenderApplyReport(report *apply.ApplyReport, err error) {
+	*r.isLoading = true
+	defer func() { *r.isLoading = false }()
+
+	r.statsBuf.SetText("")
+	title := "=== APPLY REPORT ===\n\n"
+	if err != nil {
+		title = "=== APPLICATION / VERIFICATION FAILURE ===\n\n"
+	}
+	r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), title, r.GetTag("header"))
+
+	for _, f := range report.Files {
+		tag := "added"
+		if !f.OK() {
+			tag = "deleted"
+		}
+		action := f.Action
+		if f.Mode != "" {
+			action += ", mode " + f.Mode
+		}
+		r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), fmt.Sprintf("FILE: %s (%s)\n", f.Path, action), r.GetTag(tag))
+		for _, h := range f.Hunks {
+			r.statsBuf.Insert(r.statsBuf.GetEndIter(), fmt.Sprintf("  Hunk %d: ", h.Index))
+			r.renderHunkStatus(h)
+		}
+		if msg := f.FileError(); msg != "" {
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "  "+msg+"\n", r.GetTag("deleted"))
+		}
+		if f.Formatted != "" {
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "  Formatter changes:\n", r.GetTag("header"))
+			r.statsBuf.Insert(r.statsBuf.GetEndIter(), f.Formatted)
+		}
+		r.statsBuf.Insert(r.statsBuf.GetEndIter(), "\n")
+	}
+
+	if len(report.Baseline) > 0 {
+		r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "BASELINE COMPARISON:\n", r.GetTag("header"))
+		for _, c := range report.Baseline {
+			tag := "added"
+			if c.Regressed() {
+				tag = "deleted"
+			}
+			r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "  "+c.String()+"\n", r.GetTag(tag))
+			for _, f := range c.New {
+				r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "    new: "+f+"\n", r.GetTag("deleted"))
+			}
+			for _, f := range c.PreExisting {
+				r.statsBuf.Insert(r.statsBuf.GetEndIter(), "    pre-existing: "+f+"\n")
+			}
+			for _, f := range c.Fixed {
+				r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "    fixed: "+f+"\n", r.GetTag("added"))
+			}
+		}
+		r.statsBuf.Insert(r.statsBuf.GetEndIter(), "\n")
+	}
+
+	if err != nil {
+		msg := err.Error()
+		if report.Tests.Count(gotest.Fail) > 0 {
+			// The failing tests are listed below with their output; the
+			// note on what happened to the workspace precedes it
+			msg, _, _ = strings.Cut(msg, "\n\nOutput:\n")
+		}
+		r.statsBuf.Insert(r.statsBuf.GetEndIter(), msg+"\n")
+		highlight(r.statsBuf, `(?i)error:.*`, "deleted")
+		highlight(r.statsBuf, `\./.*\.go:\d+:\d+`, "header")
+	}
+	if report.Tests != nil {
+		r.renderTests(report.Tests)
+	}
+
+	r.updateStatus(r.statusLabel, "Apply report rendered to panel")
+}
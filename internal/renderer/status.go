@@ -6,12 +6,15 @@ import (
 	"goctx/internal/model"
 )
 
+// RenderGitStatus lists the workspace changes with their real state:
+// staged or not, renamed from where, untracked or conflicted.
 func (r *Renderer) RenderGitStatus(root string) {
-
-	files, err := git.GetStatusFiles(root)
-	if err != nil {
-		r.RenderError(err)
-		return
+	var entries []git.StatusEntry
+	if repo, err := git.Open(root); err == nil {
+		if entries, err = repo.Status(git.StatusOptions{}); err != nil {
+			r.RenderError(err)
+			return
+		}
 	}
 
 	*r.isLoading = true
@@ -20,17 +23,40 @@ func (r *Renderer) RenderGitStatus(root string) {
 	r.statsBuf.SetText("")
 	r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "=== WORKSPACE MODIFICATIONS ===\n\n", r.GetTag("header"))
 
-	if len(files) == 0 {
+	if len(entries) == 0 {
 		r.statsBuf.Insert(r.statsBuf.GetEndIter(), "No changes detected.\n")
 		return
 	}
 
-	for _, f := range files {
-		r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), "  [Modified] ", r.GetTag("added"))
-		r.statsBuf.Insert(r.statsBuf.GetEndIter(), f+"\n")
+	staged, untracked := 0, 0
+	for _, e := range entries {
+		tag := "added"
+		if e.Removed() || e.Conflicted() {
+			tag = "deleted"
+		}
+		r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), fmt.Sprintf("  [%s] ", e.Label()), r.GetTag(tag))
+
+		line := e.Path
+		if e.Orig != "" {
+			line = e.Orig + " -> " + e.Path
+		}
+		switch {
+		case e.Staged() && e.Unstaged():
+			line += "  (partly staged)"
+		case e.Staged():
+			line += "  (staged)"
+		}
+		r.statsBuf.Insert(r.statsBuf.GetEndIter(), line+"\n")
+
+		if e.Staged() {
+			staged++
+		}
+		if e.Untracked() {
+			untracked++
+		}
 	}
 
-	r.statsBuf.Insert(r.statsBuf.GetEndIter(), fmt.Sprintf("\nTotal modified: %d\n", len(files)))
+	r.statsBuf.Insert(r.statsBuf.GetEndIter(), fmt.Sprintf("\nTotal changed: %d (%d staged, %d untracked)\n", len(entries), staged, untracked))
 }
 
 func (r *Renderer) RenderSummary(p model.ProjectOutput) {
@@ -0,0 +1,56 @@
+package renderer
+
+import (
+	"fmt"
+	"goctx/internal/gotest"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// renderTests lists `go test -json` results: each failure gets an expander
+// holding its output, and the passed and skipped tests share one collapsed
+// expander. Child anchors shift buffer offsets, so call this after any
+// highlight pass.
+func (r *Renderer) renderTests(tests *gotest.Report) {
+	tag := "added"
+	if tests.Count(gotest.Fail) > 0 {
+		tag = "deleted"
+	}
+	r.statsBuf.InsertWithTag(r.statsBuf.GetEndIter(), fmt.Sprintf("\nTESTS: %s\n", tests.Summary()), r.GetTag(tag))
+
+	for _, t := range tests.Failures() {
+		r.insertExpander(fmt.Sprintf("FAIL %s (%.2fs)", t.Name(), t.Elapsed.Seconds()), strings.TrimRight(t.Output, "\n"), true)
+		r.statsBuf.Insert(r.statsBuf.GetEndIter(), "\n")
+	}
+
+	var others strings.Builder
+	for _, t := range tests.Results {
+		if t.Status != gotest.Fail {
+			fmt.Fprintf(&others, "%s  %s (%.2fs)\n", strings.ToUpper(string(t.Status)), t.Name(), t.Elapsed.Seconds())
+		}
+	}
+	if others.Len() > 0 {
+		title := fmt.Sprintf("%d passed, %d skipped", tests.Count(gotest.Pass), tests.Count(gotest.Skip))
+		r.insertExpander(title, strings.TrimRight(others.String(), "\n"), false)
+		r.statsBuf.Insert(r.statsBuf.GetEndIter(), "\n")
+	}
+}
+
+// insertExpander anchors a collapsible block of monospace text at the end
+// of the buffer.
+func (r *Renderer) insertExpander(title, body string, expanded bool) {
+	anchor, _ := r.statsBuf.CreateChildAnchor(r.statsBuf.GetEndIter())
+	exp, _ := gtk.ExpanderNew(title)
+	lbl, _ := gtk.LabelNew(body)
+	lbl.SetXAlign(0)
+	lbl.SetSelectable(true)
+	lbl.SetMarginStart(20)
+	if ctx, err := lbl.GetStyleContext(); err == nil {
+		ctx.AddClass("monospace")
+	}
+	exp.Add(lbl)
+	exp.SetExpanded(expanded)
+	r.statsView.AddChildAtAnchor(exp, anchor)
+	exp.ShowAll()
+}
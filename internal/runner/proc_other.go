@@ -0,0 +1,9 @@
+//go:build !unix
+
+package runner
+
+import "os/exec"
+
+// killGroupOnCancel keeps exec's default of killing only the direct child;
+// process groups are a Unix notion.
+func killGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package runner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killGroupOnCancel starts cmd in its own process group and makes context
+// cancellation kill the whole group, so a shell's children (a hanging
+// test binary, a watcher) die with it instead of holding the output
+// pipes open.
+func killGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
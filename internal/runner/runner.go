@@ -2,7 +2,9 @@ package runner
 
 import (
 	"bufio"
+	"context"
 	"io"
+	"os"
 	"os/exec"
 	"runtime"
 	"sync"
@@ -10,13 +12,25 @@ import (
 
 // Run executes a command string and streams output to a callback.
 func Run(root, cmdStr string, onLog func(string)) ([]byte, error) {
+	return RunContext(context.Background(), root, cmdStr, nil, onLog)
+}
+
+// RunContext is Run with a context and extra environment variables
+// ("KEY=value") added to the inherited environment. When ctx ends, the
+// command and every process it started are killed and ctx.Err() is
+// returned, so callers can tell a timeout or cancellation from a failure.
+func RunContext(ctx context.Context, root, cmdStr string, env []string, onLog func(string)) ([]byte, error) {
 	var cmd *exec.Cmd
 	if runtime.GOOS == "windows" {
-		cmd = exec.Command("cmd", "/C", cmdStr)
+		cmd = exec.CommandContext(ctx, "cmd", "/C", cmdStr)
 	} else {
-		cmd = exec.Command("sh", "-c", cmdStr)
+		cmd = exec.CommandContext(ctx, "sh", "-c", cmdStr)
 	}
 	cmd.Dir = root
+	killGroupOnCancel(cmd)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	stdout, _ := cmd.StdoutPipe()
 	stderr, _ := cmd.StderrPipe()
@@ -49,5 +63,8 @@ func Run(root, cmdStr string, onLog func(string)) ([]byte, error) {
 
 	wg.Wait()
 	err := cmd.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+		return fullOutput, ctxErr
+	}
 	return fullOutput, err
 }
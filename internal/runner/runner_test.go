@@ -0,0 +1,53 @@
+//go:build unix
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunStreamsOutput(t *testing.T) {
+	// stdout and stderr are read by separate goroutines
+	var mu sync.Mutex
+	var lines []string
+	out, err := Run(t.TempDir(), "echo one; echo two >&2; exit 0", func(line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || !strings.Contains(string(out), "one") || !strings.Contains(string(out), "two") {
+		t.Errorf("got lines %q, output %q", lines, out)
+	}
+}
+
+func TestRunContextKillsProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// The backgrounded sleep inherits the output pipes; without killing the
+	// group, Run would wait for it to exit.
+	start := time.Now()
+	_, err := RunContext(ctx, t.TempDir(), "sleep 30 & sleep 30", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run took %s after its deadline", elapsed)
+	}
+}
+
+func TestRunContextReportsCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := RunContext(ctx, t.TempDir(), "sleep 30", nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+}
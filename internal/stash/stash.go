@@ -1,15 +1,85 @@
 package stash
 
 import (
+	"fmt"
+
 	"goctx/internal/git"
 )
 
-// Push creates a stash with a message
-func Push(root, message string) error {
-	return git.StashPush(root, message)
+// Entry is a stash created by goctx. It is identified by its commit, never
+// by its position in the stash list, so it cannot be confused with a stash
+// the user made.
+type Entry struct {
+	Commit  string `json:"commit"`
+	Message string `json:"message"`
+	// Paths are the files the entry holds; empty for a whole-tree stash.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// Short is the entry's abbreviated commit.
+func (e *Entry) Short() string {
+	if len(e.Commit) > 7 {
+		return e.Commit[:7]
+	}
+	return e.Commit
+}
+
+// Push stashes every change in the workspace. It returns nil when there
+// was nothing to stash.
+func Push(root, message string) (*Entry, error) {
+	repo, err := git.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.StashPush(message)
+	if err != nil || commit == "" {
+		return nil, err
+	}
+	return &Entry{Commit: commit, Message: message}, nil
+}
+
+// Save records paths as they are now in a new stash entry, without
+// changing the workspace.
+func Save(root, message string, paths []string) (*Entry, error) {
+	repo, err := git.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.StashPaths(message, paths)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{Commit: commit, Message: message, Paths: paths}, nil
+}
+
+// Recover brings back the files of e and drops it. Only e's own paths are
+// written; a whole-tree entry is popped. It fails without touching the
+// workspace when the entry no longer exists.
+func Recover(root string, e *Entry) error {
+	if e == nil {
+		return fmt.Errorf("no stash to recover")
+	}
+	repo, err := git.Open(root)
+	if err != nil {
+		return err
+	}
+	if len(e.Paths) == 0 {
+		return repo.StashPop(e.Commit)
+	}
+	if _, err := repo.FindStash(e.Commit); err != nil {
+		return err
+	}
+	if err := repo.RestorePaths(e.Commit, e.Paths); err != nil {
+		return err
+	}
+	return repo.StashDrop(e.Commit)
 }
 
 // GetCommits returns the recent git commit history
 func GetCommits(root string) ([]string, error) {
-	return git.GetLog(root, 30)
+	repo, err := git.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Log(30)
 }
@@ -0,0 +1,227 @@
+package trash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"goctx/internal/pathsafe"
+)
+
+// Dir is where deleted files are kept, relative to the project root.
+const Dir = ".trash"
+
+// indexFile records where every trashed file came from.
+const indexFile = "index.json"
+
+// Entry is one trashed file.
+type Entry struct {
+	ID          string    `json:"id"`   // file name inside Dir
+	Path        string    `json:"path"` // original path relative to root, "" if unknown
+	Trashed     time.Time `json:"trashed"`
+	Description string    `json:"description,omitempty"` // the patch that deleted it
+}
+
+// Move moves the file at path (relative to root) into the trash and records
+// it in the index.
+func Move(root, path, description string) (Entry, error) {
+	dir := filepath.Join(root, Dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Entry{}, fmt.Errorf("could not create trash directory: %w", err)
+	}
+
+	entries, err := readIndex(root)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	now := time.Now()
+	e := Entry{Path: filepath.ToSlash(path), Trashed: now, Description: description}
+
+	// Generate unique trash filename to avoid collisions
+	base := fmt.Sprintf("%s_%s", now.Format("20060102-150405"), filepath.Base(path))
+	e.ID = base
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(filepath.Join(dir, e.ID)); os.IsNotExist(err) {
+			break
+		}
+		e.ID = fmt.Sprintf("%s.%d", base, n)
+	}
+
+	if err := os.Rename(filepath.Join(root, path), filepath.Join(dir, e.ID)); err != nil {
+		return Entry{}, fmt.Errorf("could not move file to trash: %w", err)
+	}
+	if err := writeIndex(root, append(entries, e)); err != nil {
+		// Keep the file where the index can find it
+		_ = os.Rename(filepath.Join(dir, e.ID), filepath.Join(root, path))
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// List returns every trashed file, newest first. Files trashed before the
+// index existed are listed with an empty Path.
+func List(root string) ([]Entry, error) {
+	entries, err := readIndex(root)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(entries))
+	var live []Entry
+	for _, e := range entries {
+		if _, err := os.Lstat(filepath.Join(root, Dir, e.ID)); err == nil {
+			known[e.ID] = true
+			live = append(live, e)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(root, Dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.Name() == indexFile || known[f.Name()] {
+			continue
+		}
+		e := Entry{ID: f.Name()}
+		if info, err := f.Info(); err == nil {
+			e.Trashed = info.ModTime()
+		}
+		live = append(live, e)
+	}
+
+	sort.SliceStable(live, func(i, j int) bool { return live[i].Trashed.After(live[j].Trashed) })
+	return live, nil
+}
+
+// Restore moves a trashed file back to its original path. It refuses to
+// overwrite a file that has since been recreated there.
+func Restore(root, id string) (Entry, error) {
+	if id == "" || id != filepath.Base(id) || id == ".." || id == indexFile {
+		return Entry{}, fmt.Errorf("%q is not a trash entry", id)
+	}
+	entries, err := readIndex(root)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	for i, e := range entries {
+		if e.ID != id {
+			continue
+		}
+		target, err := restoreTarget(root, e.Path)
+		if err != nil {
+			return e, fmt.Errorf("%s: %w", id, err)
+		}
+		if _, err := os.Lstat(target); err == nil {
+			return e, fmt.Errorf("%s already exists", e.Path)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return e, fmt.Errorf("could not create directory: %w", err)
+		}
+		if err := os.Rename(filepath.Join(root, Dir, id), target); err != nil {
+			return e, fmt.Errorf("could not restore file: %w", err)
+		}
+		return e, writeIndex(root, append(entries[:i:i], entries[i+1:]...))
+	}
+	return Entry{}, fmt.Errorf("%s: not in the trash index, original path unknown", id)
+}
+
+// restoreTarget returns where a file trashed from path goes back to. The
+// index is a plain file anyone can edit, so its paths are checked the way a
+// patch's are, with the trash itself reserved too.
+func restoreTarget(root, path string) (string, error) {
+	if path == "" {
+		return "", errors.New("original path unknown")
+	}
+	rel, err := pathsafe.Resolve(root, filepath.FromSlash(path), Dir)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return filepath.Join(root, filepath.FromSlash(rel)), nil
+}
+
+// Purge permanently deletes trashed files older than age; zero purges
+// everything. It returns the entries removed.
+func Purge(root string, age time.Duration) ([]Entry, error) {
+	entries, err := List(root)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-age)
+	var purged []Entry
+	for _, e := range entries {
+		if age > 0 && e.Trashed.After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, Dir, e.ID)); err != nil {
+			return purged, fmt.Errorf("could not remove %s: %w", e.ID, err)
+		}
+		purged = append(purged, e)
+	}
+
+	index, err := readIndex(root)
+	if err != nil {
+		return purged, err
+	}
+	var kept []Entry
+	for _, e := range index {
+		if _, err := os.Lstat(filepath.Join(root, Dir, e.ID)); err == nil {
+			kept = append(kept, e)
+		}
+	}
+	return purged, writeIndex(root, kept)
+}
+
+func readIndex(root string) ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(root, Dir, indexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read trash index: %w", err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("could not parse trash index: %w", err)
+	}
+	return entries, nil
+}
+
+func writeIndex(root string, entries []Entry) error {
+	path := filepath.Join(root, Dir, indexFile)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not write trash index: %w", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write trash index: %w", err)
+	}
+	return nil
+}
+
+// Describe renders an entry for listings.
+func Describe(e Entry) string {
+	path := e.Path
+	if path == "" {
+		path = "(original path unknown)"
+	}
+	parts := []string{e.ID, e.Trashed.Format("2006-01-02 15:04"), path}
+	if e.Description != "" {
+		parts = append(parts, e.Description)
+	}
+	return strings.Join(parts, "  ")
+}
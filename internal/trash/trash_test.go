@@ -0,0 +1,167 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, root, path, content string) {
+	t.Helper()
+	full := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveAndRestore(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "internal/foo/a.go", "package foo\n")
+	writeFile(t, root, "internal/bar/a.go", "package bar\n")
+
+	first, err := Move(root, "internal/foo/a.go", "Drop foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Move(root, "internal/bar/a.go", "Drop bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID {
+		t.Fatalf("files with the same name must not collide: %s", first.ID)
+	}
+
+	entries, err := List(root)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("List = %v, %v", entries, err)
+	}
+	if entries[0].Path == "" || entries[0].Description == "" {
+		t.Errorf("index should record path and description: %+v", entries[0])
+	}
+
+	if _, err := Restore(root, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "internal/foo/a.go"))
+	if err != nil || string(data) != "package foo\n" {
+		t.Errorf("restored file = %q, %v", data, err)
+	}
+	if entries, _ := List(root); len(entries) != 1 || entries[0].ID != second.ID {
+		t.Errorf("restored entry should leave the index: %v", entries)
+	}
+}
+
+func TestRestoreRefusesToOverwrite(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "a.go", "old\n")
+	e, err := Move(root, "a.go", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, root, "a.go", "new\n")
+
+	if _, err := Restore(root, e.ID); err == nil {
+		t.Fatal("expected restore to refuse overwriting a.go")
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "a.go"))
+	if string(data) != "new\n" {
+		t.Errorf("a.go = %q, want it untouched", data)
+	}
+}
+
+func TestPurgeByAge(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "old.go", "old\n")
+	writeFile(t, root, "new.go", "new\n")
+
+	old, err := Move(root, "old.go", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Move(root, "new.go", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backdate the first entry
+	entries, _ := readIndex(root)
+	for i := range entries {
+		if entries[i].ID == old.ID {
+			entries[i].Trashed = time.Now().Add(-48 * time.Hour)
+		}
+	}
+	if err := writeIndex(root, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := Purge(root, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 1 || purged[0].ID != old.ID {
+		t.Errorf("Purge removed %v, want only %s", purged, old.ID)
+	}
+	if _, err := os.Stat(filepath.Join(root, Dir, old.ID)); !os.IsNotExist(err) {
+		t.Error("purged file should be gone")
+	}
+
+	if purged, _ := Purge(root, 0); len(purged) != 1 {
+		t.Errorf("Purge(0) should empty the trash, removed %v", purged)
+	}
+	if entries, _ := List(root); len(entries) != 0 {
+		t.Errorf("trash should be empty, got %v", entries)
+	}
+}
+
+func TestListIncludesUnindexedFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, filepath.Join(Dir, "20240101-120000_legacy.go"), "legacy\n")
+
+	entries, err := List(root)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("List = %v, %v", entries, err)
+	}
+	if entries[0].Path != "" {
+		t.Errorf("legacy entry has no known path, got %q", entries[0].Path)
+	}
+	if _, err := Restore(root, entries[0].ID); err == nil {
+		t.Error("restoring a file without a known path should fail")
+	}
+}
+
+func TestRestoreRejectsPathsOutsideRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "project")
+	writeFile(t, root, "a.go", "package a\n")
+	if err := os.Symlink(parent, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Move(root, "a.go", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tampered index must not send the file anywhere a patch could not
+	for _, path := range []string{"../escaped.go", filepath.Join(parent, "abs.go"), ".git/hooks/pre-commit", ".trash/x", "link/escaped.go"} {
+		tampered := e
+		tampered.Path = filepath.ToSlash(path)
+		if err := writeIndex(root, []Entry{tampered}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Restore(root, e.ID); err == nil {
+			t.Errorf("%s: restore should be refused", path)
+		}
+	}
+	if _, err := Restore(root, "../project/a.go"); err == nil {
+		t.Error("an ID naming a path outside the trash should be refused")
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+		t.Errorf("nothing may be written beside the project: %v", entries)
+	}
+	if _, err := os.Lstat(filepath.Join(root, Dir, e.ID)); err != nil {
+		t.Errorf("the trashed file should stay in the trash: %v", err)
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"goctx/internal/apply"
 	"goctx/internal/builder"
 	"goctx/internal/git"
+	"goctx/internal/model"
+	"goctx/internal/patch"
+	"goctx/internal/pipeline"
 	"goctx/internal/renderer"
-	"os/exec"
+	"goctx/internal/stash"
 	"strings"
 
 	"github.com/gotk3/gotk3/gdk"
@@ -40,13 +43,12 @@ func bindEvents(r *renderer.Renderer) {
 		showKeyManager()
 	})
 
-	btnRunBuild.Connect("clicked", func() {
-		go runVerification("build", true, r)
+	btnTrash.Connect("clicked", func() {
+		showTrashManager()
 	})
 
-	btnRunTest.Connect("clicked", func() {
-		go runVerification("test", true, r)
-	})
+	refreshStepButtons(r)
+	btnCancel.Connect("clicked", cancelRun)
 
 	btnCopy.Connect("clicked", func() {
 		fullPrompt := builder.AI_PROMPT_HEADER + string(mustMarshal(activeContext))
@@ -70,7 +72,14 @@ func bindEvents(r *renderer.Renderer) {
 		pathMu.Unlock()
 		statsView.SetEditable(false)
 		idx := row.GetIndex()
-		r.RenderDiff(pendingPatches[idx], "Pending Patch Preview")
+		pendingSelection = patch.NewSelection(pendingPatches[idx])
+		check, err := apply.Check(".", pendingPatches[idx])
+		if err != nil {
+			r.RenderError(err)
+			btnApplyPatch.SetSensitive(false)
+			return
+		}
+		r.RenderDiff(pendingPatches[idx], check, "Pending Patch Preview", pendingSelection)
 		btnApplyPatch.SetSensitive(true)
 		btnApplyCommit.SetSensitive(false)
 	})
@@ -91,12 +100,20 @@ func bindEvents(r *renderer.Renderer) {
 		parts := strings.Fields(fullText)
 		if len(parts) > 0 {
 			hash := parts[0]
-			showCmd := exec.Command("git", "show", "--color=never", hash)
-			out, _ := showCmd.Output()
+			repo, err := git.Open(".")
+			if err != nil {
+				r.RenderError(err)
+				return
+			}
+			out, err := repo.Show(hash)
+			if err != nil {
+				r.RenderError(err)
+				return
+			}
 			isLoadingState = true
 			statsBuf.SetText("")
 			statsBuf.InsertWithTag(statsBuf.GetEndIter(), "COMMIT PREVIEW: "+hash+"\n\n", r.GetTag("header"))
-			statsBuf.Insert(statsBuf.GetEndIter(), string(out))
+			statsBuf.Insert(statsBuf.GetEndIter(), out)
 			isLoadingState = false
 			btnApplyCommit.SetSensitive(true)
 			btnApplyPatch.SetSensitive(false)
@@ -135,8 +152,14 @@ func handleCommitAction() {
 	if !ok || strings.TrimSpace(msg) == "" {
 		return
 	}
-	git.AddAll(".")
-	if err := git.Commit(".", msg); err != nil {
+	repo, err := git.Open(".")
+	if err == nil {
+		err = repo.AddAll()
+	}
+	if err == nil {
+		err = repo.Commit(msg)
+	}
+	if err != nil {
 		updateStatus(statusLabel, "Failed: "+err.Error())
 	} else {
 		updateStatus(statusLabel, "Committed")
@@ -157,8 +180,11 @@ func handleRestoreCommitAction() {
 	if len(parts) > 0 {
 		hash := parts[0]
 		if confirmAction(win, "Restoring "+hash+" will overwrite current changes. Proceed?") {
-			cmd := exec.Command("git", "checkout", hash, "--", ".")
-			if err := cmd.Run(); err != nil {
+			repo, err := git.Open(".")
+			if err == nil {
+				err = repo.Checkout(hash)
+			}
+			if err != nil {
 				updateStatus(statusLabel, "Error: "+err.Error())
 			} else {
 				updateStatus(statusLabel, "Restored "+hash)
@@ -171,6 +197,7 @@ func handleRestoreCommitAction() {
 func handleApplyPatchAction(r interface {
 	RenderGitStatus(root string)
 	RenderError(err error)
+	RenderApplyReport(report *apply.ApplyReport, err error)
 	GetTag(n string) *gtk.TextTag
 }) {
 	row := pendingPanel.List.GetSelectedRow()
@@ -179,13 +206,79 @@ func handleApplyPatchAction(r interface {
 	}
 	idx := row.GetIndex()
 	patchToApply := pendingPatches[idx]
-	stat, _ := exec.Command("git", "status", "--porcelain").Output()
-	isDirty := len(strings.TrimSpace(string(stat))) > 0
+	var remainder model.ProjectOutput
+	if pendingSelection != nil {
+		patchToApply, remainder = pendingSelection.Split(pendingPatches[idx])
+	}
+	if len(patchToApply.Files) == 0 {
+		updateStatus(statusLabel, "Nothing selected to apply")
+		return
+	}
+	// One dry run answers every question asked before applying
+	var opts apply.Options
+	check, err := apply.CheckWithOptions(".", patchToApply, opts)
+	if err != nil {
+		r.RenderError(err)
+		return
+	}
+	if low := check.LowConfidenceMatches(); len(low) > 0 {
+		var sb strings.Builder
+		sb.WriteString("Some hunks only matched approximately:\n\n")
+		for _, m := range low {
+			sb.WriteString(fmt.Sprintf("%s hunk %d: lines %d-%d (%.0f%% confidence)\n", m.Path, m.Hunk, m.Match.Start+1, m.Match.End, m.Match.Score*100))
+		}
+		sb.WriteString("\nApply anyway?")
+		if !confirmAction(win, sb.String()) {
+			return
+		}
+	}
+	// Protected files (build scripts, keys, CI) need a yes for each one
+	// The pills follow the steps run on the patch, not only manual runs
+	opts.OnStep = func(res pipeline.Result) {
+		glib.IdleAdd(func() {
+			if btn := stepButtons[pipeline.Label(res.Step)]; btn != nil {
+				showStepResult(btn, res)
+			}
+		})
+	}
+	for _, path := range check.ProtectedFiles() {
+		if !confirmAction(win, fmt.Sprintf("%s is a protected file.\n\nAllow this patch to change it?", path)) {
+			updateStatus(statusLabel, "Apply cancelled: "+path+" is protected")
+			return
+		}
+		opts.AllowProtected = append(opts.AllowProtected, path)
+	}
+	if trashed := check.Deletions(); len(trashed) > 0 {
+		var sb strings.Builder
+		sb.WriteString("This patch deletes the following files (they will be moved to .trash):\n\n")
+		for _, path := range trashed {
+			sb.WriteString(path + "\n")
+		}
+		sb.WriteString("\nDelete them?")
+		if !confirmAction(win, sb.String()) {
+			return
+		}
+	}
+	// Outside git there is nothing to stash, so the workspace counts as clean
+	isDirty := false
+	if repo, err := git.Open("."); err == nil {
+		if isDirty, err = repo.IsDirty(); err != nil {
+			r.RenderError(err)
+			return
+		}
+	}
 	shouldProceed := false
 	if isDirty {
 		choice := askStashOrApply(win)
 		if choice == 1 {
-			exec.Command("git", "stash", "push", "-m", "GoCtx: Pre-patch stash").Run()
+			entry, err := stash.Push(".", "GoCtx: Pre-patch stash")
+			if err != nil {
+				r.RenderError(err)
+				return
+			}
+			if entry != nil {
+				updateStatus(statusLabel, fmt.Sprintf("Your changes were stashed as %s", entry.Short()))
+			}
 			shouldProceed = true
 		} else if choice == 0 {
 			shouldProceed = true
@@ -194,11 +287,16 @@ func handleApplyPatchAction(r interface {
 		shouldProceed = confirmAction(win, "Apply selected patch?")
 	}
 	if shouldProceed {
+		ctx, ok := beginRun()
+		if !ok {
+			updateStatus(statusLabel, "A verification is already running")
+			return
+		}
 		statsBuf.SetText("")
 		isLoadingState = true
 		header.SetSubtitle("Applying Patch...")
 		go func() {
-			err := apply.ApplyPatch(".", patchToApply, func(phase, desc, logLine string) {
+			report, err := apply.ApplyPatchContext(ctx, ".", patchToApply, func(phase, desc, logLine string) {
 				glib.IdleAdd(func() {
 					if phase != "" {
 						updateStatus(statusLabel, fmt.Sprintf("Phase: %s", phase))
@@ -211,25 +309,39 @@ func handleApplyPatchAction(r interface {
 						statsView.ScrollToMark(mark, 0.0, false, 0.0, 1.0)
 					}
 				})
-			})
+			}, opts)
 			glib.IdleAdd(func() {
+				endRun()
 				isLoadingState = false
 				header.SetSubtitle("Stash-Apply-Commit Workflow")
 				if err == nil {
-					pendingPatches = append(pendingPatches[:idx], pendingPatches[idx+1:]...)
-					pendingPanel.List.Remove(row)
+					retirePendingPatch(idx, row, remainder)
 					updateStatus(statusLabel, "Patch applied and verified")
+					for _, c := range report.Baseline {
+						if c.Failed {
+							updateStatus(statusLabel, "Patch applied; failures that predate it remain")
+						}
+					}
 					clearAllSelections()
 					refreshHistory(historyPanel.List)
 					lastAppliedDesc = patchToApply.ShortDescription
+					refreshStepButtons(mainRenderer)
 					r.RenderGitStatus(".")
 				} else {
-					r.RenderError(err)
-					if !strings.Contains(err.Error(), "PATCH_ERROR") {
-						if confirmAction(win, "Verification failed. Pop stash to keep changes?") {
-							exec.Command("git", "stash", "pop").Run()
-							pendingPatches = append(pendingPatches[:idx], pendingPatches[idx+1:]...)
-							pendingPanel.List.Remove(row)
+					if report.HasDetails() {
+						r.RenderApplyReport(report, err)
+					} else {
+						r.RenderError(err)
+					}
+					// Only the stash this apply created is offered back; an
+					// isolated run never touched the workspace and has none
+					if report != nil && report.Recovery != nil {
+						if confirmAction(win, fmt.Sprintf("Verification failed. Restore the patched files from stash %s to keep the changes?", report.Recovery.Short())) {
+							if err := stash.Recover(".", report.Recovery); err != nil {
+								r.RenderError(err)
+								return
+							}
+							retirePendingPatch(idx, row, remainder)
 							clearAllSelections()
 							refreshHistory(historyPanel.List)
 							r.RenderGitStatus(".")
@@ -240,3 +352,14 @@ func handleApplyPatchAction(r interface {
 		}()
 	}
 }
+
+// retirePendingPatch removes an applied patch from the sidebar. Hunks the
+// reviewer rejected are queued again as a new pending patch.
+func retirePendingPatch(idx int, row *gtk.ListBoxRow, remainder model.ProjectOutput) {
+	pendingPatches = append(pendingPatches[:idx], pendingPatches[idx+1:]...)
+	pendingPanel.List.Remove(row)
+	pendingSelection = nil
+	if len(remainder.Files) > 0 {
+		addPatchToSidebar(remainder)
+	}
+}
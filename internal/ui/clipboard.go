@@ -37,7 +37,20 @@ func dispatchPatches(outputs []model.ProjectOutput) {
 	}
 
 	glib.IdleAdd(func() {
+		// Skip patches already pending, e.g. the same reply copied twice
+		seen := make(map[string]bool)
+		for _, p := range pendingPatches {
+			seen[patch.Fingerprint(p)] = true
+		}
+
+		added := 0
 		for _, p := range outputs {
+			fp := patch.Fingerprint(p)
+			if seen[fp] {
+				continue
+			}
+			seen[fp] = true
+			added++
 			addPatchToSidebar(p)
 
 			// Extract meaningful metadata for the notification
@@ -63,7 +76,11 @@ func dispatchPatches(outputs []model.ProjectOutput) {
 
 			sendNotification(title, body)
 		}
-		updateStatus(statusLabel, fmt.Sprintf("Detected %d new patches", len(outputs)))
+		if added == 0 {
+			updateStatus(statusLabel, "Patch already pending")
+			return
+		}
+		updateStatus(statusLabel, fmt.Sprintf("Detected %d new patches", added))
 	})
 }
 
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"goctx/internal/model"
+	"goctx/internal/patch"
 	"goctx/internal/renderer"
 	"sync"
 
@@ -19,6 +20,7 @@ var (
 	lastAppliedDesc    string
 	currentEditingPath string
 	pendingPatches     []model.ProjectOutput
+	pendingSelection   patch.Selection
 	isRefreshing       bool
 	debounceID         glib.SourceHandle
 	mainTreeView       *gtk.TreeView
@@ -38,9 +40,10 @@ var (
 	btnApplyPatch  *gtk.Button
 	btnApplyCommit *gtk.Button
 	btnCommit      *gtk.Button
-	btnRunBuild    *gtk.Button
-	btnRunTest     *gtk.Button
+	btnCancel      *gtk.Button
+	stepBox        *gtk.Box
 	btnBuild       *gtk.Button
 	btnCopy        *gtk.Button
 	btnKeys        *gtk.Button
+	btnTrash       *gtk.Button
 )
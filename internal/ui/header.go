@@ -14,21 +14,24 @@ func headerComponent() *gtk.HeaderBar {
 	btnApplyCommit = createToolBtn("edit-undo-symbolic", "Restore to this commit state")
 	btnCommit = createToolBtn("emblem-ok-symbolic", "Commit all changes")
 	btnKeys = createToolBtn("dialog-password-symbolic", "Manage API Keys")
-	btnRunBuild = createToolBtn("system-run-symbolic", "Run Build")
-	btnRunTest = createToolBtn("media-playback-start-symbolic", "Run Tests")
+	btnTrash = createToolBtn("user-trash-symbolic", "Restore or empty deleted files")
+	btnCancel = createToolBtn("process-stop-symbolic", "Cancel running verification")
+	stepBox, _ = gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 2)
 
 	btnApplyPatch.SetSensitive(false)
 	btnApplyCommit.SetSensitive(false)
 	btnCommit.SetSensitive(false)
+	btnCancel.SetSensitive(false)
 
 	hb.PackStart(btnBuild)
 	hb.PackStart(btnCopy)
 	hb.PackStart(btnApplyCommit)
 
 	hb.PackEnd(btnKeys)
+	hb.PackEnd(btnTrash)
 	hb.PackEnd(btnCommit)
-	hb.PackEnd(btnRunTest)
-	hb.PackEnd(btnRunBuild)
+	hb.PackEnd(btnCancel)
+	hb.PackEnd(stepBox)
 	hb.PackEnd(btnApplyPatch)
 
 	return hb
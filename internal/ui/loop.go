@@ -1,11 +1,12 @@
 package ui
 
 import (
+	"context"
 	"fmt"
-	"goctx/internal/config"
 	"goctx/internal/git"
+	"goctx/internal/model"
+	"goctx/internal/pipeline"
 	"goctx/internal/renderer"
-	"goctx/internal/runner"
 	"strings"
 	"time"
 
@@ -46,7 +47,15 @@ func refreshGitState() {
 	if btnCommit == nil {
 		return
 	}
-	hasChanges := git.IsDirty(".")
+	hasChanges := false
+	if repo, err := git.Open("."); err == nil {
+		// A failed status keeps the last known state
+		dirty, err := repo.IsDirty()
+		if err != nil {
+			return
+		}
+		hasChanges = dirty
+	}
 	btnCommit.SetSensitive(hasChanges)
 
 	currentCount := countCommits()
@@ -56,20 +65,11 @@ func refreshGitState() {
 	}
 }
 
-func runVerification(mode string, verbose bool, r *renderer.Renderer) {
-	cfg, _ := config.Load(".")
-	var cmd string
-	var btn *gtk.Button
-
-	if mode == "build" {
-		cmd = cfg.Scripts.Build
-		btn = btnRunBuild
-	} else {
-		cmd = cfg.Scripts.Test
-		btn = btnRunTest
-	}
-
-	if cmd == "" || btn == nil {
+func runVerification(runCtx context.Context, step model.Step, verbose bool, r *renderer.Renderer) {
+	name := pipeline.Label(step)
+	btn := stepButtons[name]
+	if step.Run == "" || btn == nil {
+		glib.IdleAdd(endRun)
 		return
 	}
 
@@ -77,13 +77,13 @@ func runVerification(mode string, verbose bool, r *renderer.Renderer) {
 		glib.IdleAdd(func() {
 			isLoadingState = true
 			statsBuf.SetText("")
-			statsBuf.InsertWithTag(statsBuf.GetEndIter(), fmt.Sprintf("=== MANUAL %s STARTING ===\n", strings.ToUpper(mode)), r.GetTag("header"))
-			updateStatus(statusLabel, "Running "+mode+"...")
+			statsBuf.InsertWithTag(statsBuf.GetEndIter(), fmt.Sprintf("=== MANUAL %s STARTING ===\n", strings.ToUpper(name)), r.GetTag("header"))
+			updateStatus(statusLabel, "Running "+name+"...")
 		})
 	}
 
-	// Use internal runner to execute and stream logs if verbose
-	out, err := runner.Run(".", cmd, func(line string) {
+	// Use the pipeline runner to execute and stream logs if verbose
+	res := pipeline.RunStep(runCtx, ".", step, func(line string) {
 		if verbose {
 			glib.IdleAdd(func() {
 				statsBuf.Insert(statsBuf.GetEndIter(), line+"\n")
@@ -93,26 +93,36 @@ func runVerification(mode string, verbose bool, r *renderer.Renderer) {
 			})
 		}
 	})
+	out, err := res.Output, res.Err
 
 	glib.IdleAdd(func() {
-		ctx, _ := btn.GetStyleContext()
-		ctx.RemoveClass("btn-success")
-		ctx.RemoveClass("btn-failure")
+		endRun()
+		showStepResult(btn, res)
 
-		if err != nil {
-			ctx.AddClass("btn-failure")
-			btn.SetTooltipText(fmt.Sprintf("Last %s failed: %v", mode, err))
+		if res.Canceled() {
+			if verbose {
+				statsBuf.InsertWithTag(statsBuf.GetEndIter(), "\nCANCELLED\n", r.GetTag("header"))
+				updateStatus(statusLabel, name+" cancelled")
+				isLoadingState = false
+			}
+			return
+		}
+
+		if res.TimedOut() {
+			if verbose {
+				statsBuf.InsertWithTag(statsBuf.GetEndIter(), fmt.Sprintf("\nTIMED OUT: %s %v\n", name, err), r.GetTag("deleted"))
+				updateStatus(statusLabel, name+" timed out")
+			}
+		} else if err != nil {
 			if verbose {
 				statsBuf.InsertWithTag(statsBuf.GetEndIter(), fmt.Sprintf("\nFAILED: %v\n", err), r.GetTag("deleted"))
-				updateStatus(statusLabel, mode+" failed")
+				updateStatus(statusLabel, name+" failed")
 			}
 		} else {
-			ctx.AddClass("btn-success")
-			btn.SetTooltipText(fmt.Sprintf("Last %s passed", mode))
 			if verbose {
-				successMsg := fmt.Sprintf("\nSUCCESS: %s completed successfully.\n", strings.ToUpper(mode))
+				successMsg := fmt.Sprintf("\nSUCCESS: %s completed successfully.\n", strings.ToUpper(name))
 				statsBuf.InsertWithTag(statsBuf.GetEndIter(), successMsg, r.GetTag("added"))
-				updateStatus(statusLabel, mode+" passed")
+				updateStatus(statusLabel, name+" passed")
 			}
 		}
 
@@ -120,8 +130,8 @@ func runVerification(mode string, verbose bool, r *renderer.Renderer) {
 			isLoadingState = false
 		} else if err != nil && !isLoadingState {
 			// If background check failed and the user is not currently looking at something else
-			updateStatus(statusLabel, fmt.Sprintf("Background %s failed", mode))
-			r.RenderError(fmt.Errorf("%s output:\n%s", mode, string(out)))
+			updateStatus(statusLabel, fmt.Sprintf("Background %s failed", name))
+			r.RenderError(fmt.Errorf("%s output:\n%s", name, string(out)))
 		}
 	})
 }
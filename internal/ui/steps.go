@@ -0,0 +1,140 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"goctx/internal/config"
+	"goctx/internal/pipeline"
+	"goctx/internal/renderer"
+	"time"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+var (
+	// stepButtons maps a pipeline step's label to its header button, which
+	// doubles as a pass/fail pill.
+	stepButtons = map[string]*gtk.Button{}
+	// stopRun cancels the verification in progress; nil when idle.
+	stopRun context.CancelFunc
+)
+
+// beginRun starts a cancellable verification and enables the Cancel button.
+// It returns false while another run is in progress. Main thread only.
+func beginRun() (context.Context, bool) {
+	if stopRun != nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopRun = cancel
+	btnCancel.SetSensitive(true)
+	return ctx, true
+}
+
+// endRun releases the run started by beginRun. Main thread only.
+func endRun() {
+	if stopRun != nil {
+		stopRun()
+		stopRun = nil
+	}
+	btnCancel.SetSensitive(false)
+}
+
+// cancelRun stops the verification in progress. Its goroutine still
+// finishes and reports the cancellation.
+func cancelRun() {
+	if stopRun != nil {
+		stopRun()
+		updateStatus(statusLabel, "Cancelling...")
+	}
+}
+
+// refreshStepButtons rebuilds the header buttons from the configured
+// pipeline. A step that is still configured keeps its last status. With an
+// invalid goctx.json no step is offered, rather than a partial pipeline.
+func refreshStepButtons(r *renderer.Renderer) {
+	cfg, err := config.Load(".")
+	if err != nil {
+		updateStatus(statusLabel, "Verification disabled: invalid goctx.json")
+		r.RenderError(err)
+	}
+
+	old := stepButtons
+	stepButtons = map[string]*gtk.Button{}
+	stepBox.GetChildren().Foreach(func(item interface{}) {
+		if w, ok := item.(*gtk.Widget); ok {
+			stepBox.Remove(w)
+		}
+	})
+
+	for _, step := range cfg.Scripts {
+		if step.Run == "" {
+			continue
+		}
+		step := step
+		name := pipeline.Label(step)
+		if _, dup := stepButtons[name]; dup {
+			continue
+		}
+
+		btn, _ := gtk.ButtonNewWithLabel(name)
+		if step.Advisory {
+			btn.SetTooltipText("Run " + step.Run + " (advisory)")
+		} else {
+			btn.SetTooltipText("Run " + step.Run)
+		}
+		if prev := old[name]; prev != nil {
+			if tip, err := prev.GetTooltipText(); err == nil {
+				btn.SetTooltipText(tip)
+			}
+			prevCtx, _ := prev.GetStyleContext()
+			ctx, _ := btn.GetStyleContext()
+			for _, class := range []string{"btn-success", "btn-failure"} {
+				if prevCtx.HasClass(class) {
+					ctx.AddClass(class)
+				}
+			}
+		}
+		btn.Connect("clicked", func() {
+			ctx, ok := beginRun()
+			if !ok {
+				updateStatus(statusLabel, "A verification is already running")
+				return
+			}
+			go runVerification(ctx, step, true, r)
+		})
+		stepButtons[name] = btn
+		stepBox.PackStart(btn, false, false, 0)
+	}
+	stepBox.ShowAll()
+}
+
+// showStepResult colours a step's pill and explains its last run in the
+// tooltip, whether the step ran by hand or while applying a patch. A
+// skipped step keeps its previous status. Main thread only.
+func showStepResult(btn *gtk.Button, res pipeline.Result) {
+	if res.Skipped {
+		return
+	}
+	ctx, _ := btn.GetStyleContext()
+	ctx.RemoveClass("btn-success")
+	ctx.RemoveClass("btn-failure")
+
+	run := res.Step.Run
+	switch {
+	case res.Canceled():
+		btn.SetTooltipText(fmt.Sprintf("%s\nLast run was cancelled", run))
+	case res.TimedOut():
+		ctx.AddClass("btn-failure")
+		btn.SetTooltipText(fmt.Sprintf("%s\nLast run %v", run, res.Err))
+	case res.Accepted:
+		ctx.AddClass("btn-failure")
+		btn.SetTooltipText(fmt.Sprintf("%s\nLast run failed as it did before the patch", run))
+	case res.Err != nil:
+		ctx.AddClass("btn-failure")
+		btn.SetTooltipText(fmt.Sprintf("%s\nLast run failed: %v", run, res.Err))
+	default:
+		ctx.AddClass("btn-success")
+		btn.SetTooltipText(fmt.Sprintf("%s\nLast run passed in %s", run, res.Duration.Round(time.Millisecond)))
+	}
+}
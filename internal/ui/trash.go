@@ -0,0 +1,128 @@
+package ui
+
+import (
+	"fmt"
+	"goctx/internal/trash"
+	"time"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// trashAges are the choices offered when emptying the trash.
+var trashAges = []struct {
+	label string
+	age   time.Duration
+}{
+	{"Everything", 0},
+	{"Older than 1 day", 24 * time.Hour},
+	{"Older than 7 days", 7 * 24 * time.Hour},
+	{"Older than 30 days", 30 * 24 * time.Hour},
+}
+
+func showTrashManager() {
+	dialog, _ := gtk.DialogNew()
+	dialog.SetTitle("Trash")
+	dialog.SetTransientFor(win)
+	dialog.SetModal(true)
+	dialog.AddButton("_Close", gtk.RESPONSE_CLOSE)
+	dialog.SetDefaultSize(560, 420)
+
+	content, _ := dialog.GetContentArea()
+
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 12)
+	vbox.SetMarginStart(18)
+	vbox.SetMarginEnd(18)
+	vbox.SetMarginTop(18)
+	vbox.SetMarginBottom(18)
+
+	headerLbl, _ := gtk.LabelNew("")
+	headerLbl.SetMarkup("<span weight='bold' size='large'>Deleted Files</span>")
+	headerLbl.SetXAlign(0)
+	vbox.PackStart(headerLbl, false, false, 0)
+
+	desc, _ := gtk.LabelNew("Files deleted by patches are kept in .trash. Restore one to its original location or empty the trash by age.")
+	desc.SetLineWrap(true)
+	desc.SetXAlign(0)
+	desc.SetOpacity(0.7)
+	vbox.PackStart(desc, false, false, 0)
+
+	sw, _ := gtk.ScrolledWindowNew(nil, nil)
+	sw.SetShadowType(gtk.SHADOW_IN)
+	sw.SetVExpand(true)
+	list, _ := gtk.ListBoxNew()
+	list.SetSelectionMode(gtk.SELECTION_NONE)
+	sw.Add(list)
+	vbox.PackStart(sw, true, true, 0)
+
+	var refresh func()
+	refresh = func() {
+		list.GetChildren().Foreach(func(item interface{}) { list.Remove(item.(gtk.IWidget)) })
+
+		entries, err := trash.List(".")
+		if err != nil {
+			updateStatus(statusLabel, "Failed to read trash: "+err.Error())
+		}
+		if len(entries) == 0 {
+			lbl, _ := gtk.LabelNew("Trash is empty")
+			lbl.SetOpacity(0.7)
+			list.Add(lbl)
+		}
+
+		for _, e := range entries {
+			row, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 5)
+
+			text := e.Path
+			if text == "" {
+				text = e.ID + " (original path unknown)"
+			}
+			lbl, _ := gtk.LabelNew(fmt.Sprintf("%s\n%s  %s", text, e.Trashed.Format("2006-01-02 15:04"), e.Description))
+			lbl.SetXAlign(0)
+			row.PackStart(lbl, true, true, 5)
+
+			btn, _ := gtk.ButtonNewWithLabel("Restore")
+			btn.SetSensitive(e.Path != "")
+			btn.Connect("clicked", func() {
+				if _, err := trash.Restore(".", e.ID); err != nil {
+					updateStatus(statusLabel, "Restore failed: "+err.Error())
+					return
+				}
+				updateStatus(statusLabel, "Restored "+e.Path)
+				refresh()
+			})
+			row.PackEnd(btn, false, false, 2)
+			list.Add(row)
+		}
+		list.ShowAll()
+	}
+	refresh()
+
+	purgeBox, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 6)
+	ageCombo, _ := gtk.ComboBoxTextNew()
+	for _, a := range trashAges {
+		ageCombo.AppendText(a.label)
+	}
+	ageCombo.SetActive(2)
+	purgeBtn, _ := gtk.ButtonNewWithLabel("Empty Trash")
+	purgeBtn.Connect("clicked", func() {
+		choice := trashAges[ageCombo.GetActive()]
+		if !confirmAction(win, fmt.Sprintf("Permanently delete trashed files (%s)?", choice.label)) {
+			return
+		}
+		purged, err := trash.Purge(".", choice.age)
+		if err != nil {
+			updateStatus(statusLabel, "Purge failed: "+err.Error())
+		} else {
+			updateStatus(statusLabel, fmt.Sprintf("Purged %d file(s) from trash", len(purged)))
+		}
+		refresh()
+	})
+	purgeBox.PackStart(ageCombo, false, false, 0)
+	purgeBox.PackStart(purgeBtn, false, false, 0)
+	vbox.PackStart(purgeBox, false, false, 0)
+
+	content.Add(vbox)
+	content.ShowAll()
+
+	dialog.Run()
+	dialog.Destroy()
+}
@@ -49,7 +49,7 @@ func Run() {
 	win.Add(overlay)
 
 	// Rendering logic init
-	mainRenderer = renderer.NewRenderer(statsBuf, &isLoadingState, statusLabel, updateStatus)
+	mainRenderer = renderer.NewRenderer(statsBuf, statsView, &isLoadingState, statusLabel, updateStatus)
 	renderer.SetupTags(statsBuf)
 
 	bindEvents(mainRenderer)
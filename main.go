@@ -2,13 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"goctx/internal/apply"
 	"goctx/internal/builder"
+	"goctx/internal/model"
 	"goctx/internal/patch"
+	"goctx/internal/trash"
 	"goctx/internal/ui"
 	"io"
 	"os"
+	"strings"
 )
 
 func main() {
@@ -19,11 +23,13 @@ func main() {
 
 	switch os.Args[1] {
 	case "apply":
-		runApply()
+		runApply(os.Args[2:])
+	case "trash":
+		runTrash(os.Args[2:])
 	case "gui":
 		ui.Run()
 	default:
-		fmt.Println("Commands: apply, gui")
+		fmt.Println("Commands: apply, trash, gui")
 	}
 }
 
@@ -33,7 +39,22 @@ func runBuild() {
 	json.NewEncoder(os.Stdout).Encode(output)
 }
 
-func runApply() {
+func runApply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "validate the patch and print the resulting diff without writing")
+	check := fs.Bool("check", false, "alias for --dry-run")
+	asJSON := fs.Bool("json", false, "with --dry-run, print a JSON report instead of a diff")
+	allow := fs.String("allow-protected", "", "comma-separated protected files this patch may change (e.g. go.mod,goctx.json)")
+	isolated := fs.Bool("isolated", false, "verify the patch in a temporary worktree or copy before writing it")
+	fs.Parse(args)
+
+	opts := apply.Options{Isolated: *isolated}
+	for _, path := range strings.Split(*allow, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			opts.AllowProtected = append(opts.AllowProtected, path)
+		}
+	}
+
 	data, _ := io.ReadAll(os.Stdin)
 	text := string(data)
 
@@ -44,20 +65,116 @@ func runApply() {
 		os.Exit(1)
 	}
 
+	if *dryRun || *check {
+		runCheck(input, *asJSON, opts)
+		return
+	}
+
 	// Progress tracking for CLI
-	err := apply.ApplyPatch(".", input, func(phase, desc, logLine string) {
+	report, err := apply.ApplyPatchWithOptions(".", input, func(phase, desc, logLine string) {
 		if phase != "" {
 			fmt.Printf("\n[%s] %s\n", phase, desc)
 		}
 		if logLine != "" {
 			fmt.Printf("  %s\n", logLine)
 		}
-	})
+	}, opts)
 
 	if err != nil {
+		if report.HasDetails() {
+			fmt.Fprintf(os.Stderr, "\n%s", report)
+		}
 		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		if protected := report.ProtectedFiles(); len(protected) > 0 {
+			fmt.Fprintf(os.Stderr, "Rerun with --allow-protected=%s to confirm.\n", strings.Join(protected, ","))
+		}
 		os.Exit(1)
 	}
 
+	fmt.Printf("\n%s", report)
 	fmt.Println("\nPatch applied successfully.")
 }
+
+// runCheck reports whether a patch would apply cleanly without touching the
+// workspace, exiting non-zero if any file or hunk fails.
+func runCheck(input model.ProjectOutput, asJSON bool, opts apply.Options) {
+	report, err := apply.CheckWithOptions(".", input, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			OK bool `json:"ok"`
+			*apply.ApplyReport
+		}{report.OK(), report})
+	} else {
+		for _, f := range report.Files {
+			fmt.Print(f.Diff)
+		}
+		fmt.Fprint(os.Stderr, report)
+	}
+
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+// runTrash lists, restores or purges files deleted by patches.
+func runTrash(args []string) {
+	usage := "Usage: goctx trash list | restore <id>... | purge [--older-than 168h]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		entries, err := trash.List(".")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(entries) == 0 {
+			fmt.Println("Trash is empty.")
+		}
+		for _, e := range entries {
+			fmt.Println(trash.Describe(e))
+		}
+	case "restore":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		failed := false
+		for _, id := range args[1:] {
+			e, err := trash.Restore(".", id)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Restored %s\n", e.Path)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "purge":
+		fs := flag.NewFlagSet("trash purge", flag.ExitOnError)
+		olderThan := fs.Duration("older-than", 0, "only purge files trashed longer ago than this (default: everything)")
+		fs.Parse(args[1:])
+
+		purged, err := trash.Purge(".", *olderThan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Purged %d file(s).\n", len(purged))
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}